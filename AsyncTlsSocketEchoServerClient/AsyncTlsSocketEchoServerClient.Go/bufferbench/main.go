@@ -0,0 +1,235 @@
+package main
+
+// bufferbench compares the buffer strategies of the echo handlers with many
+// connections. For each strategy, it starts a server in-process, connects
+// the clients over loopback, and has every client echo messages. It reports
+// throughput, allocations per message, and heap in use per connection while
+// connected. Clients run in the same process, but do the same work for every
+// strategy, so differences between strategies are the server's.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/echoserver"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+)
+
+type config struct {
+	clients               int
+	messages              int
+	messagesPerConnection int
+	size                  int
+	serverTLS             *tls.Config // Nil for plain TCP.
+	clientTLS             *tls.Config
+}
+
+type result struct {
+	strategy    string
+	elapsed     time.Duration
+	messages    int
+	allocs      uint64
+	allocBytes  uint64
+	heapPerConn float64
+}
+
+func main() {
+	clients := flag.Int("clients", 2000, "number of concurrent clients")
+	messages := flag.Int("messages", 100, "number of messages each client echoes")
+	messagesPerConnection := flag.Int("messagesPerConnection", 0, "messages before a client reconnects, or 0 to keep one connection")
+	size := flag.Int("size", 512, "message size in bytes")
+	bufferSize := flag.Int("bufferSize", 1024, "size of the echo and echo-pooled handlers' read buffer")
+	strategies := flag.String("strategies", "echo,echo-pooled,echo-copy", "comma separated handlers to compare")
+	useTLS := flag.Bool("tls", false, "connect over TLS with generated certificates rather than plain TCP")
+	flag.Parse()
+
+	handlers := map[string]echoserver.Handler{
+		"echo":        echoserver.EchoBuffer(*bufferSize),
+		"echo-pooled": echoserver.EchoPooled(echoserver.NewBufferPool(*bufferSize)),
+		"echo-copy":   echoserver.EchoCopy(),
+	}
+
+	cfg := config{
+		clients:               *clients,
+		messages:              *messages,
+		messagesPerConnection: *messagesPerConnection,
+		size:                  *size,
+	}
+	if *useTLS {
+		var err error
+		if cfg.serverTLS, cfg.clientTLS, err = generateTLS(); err != nil {
+			log.Fatalf("unable to generate certificates: %s", err)
+		}
+	}
+
+	var results []result
+	for _, name := range strings.Split(*strategies, ",") {
+		handler, ok := handlers[name]
+		if !ok {
+			log.Fatalf("unknown strategy: %s", name)
+		}
+		r, err := run(name, handler, cfg)
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		results = append(results, r)
+	}
+
+	fmt.Printf("%-12s %12s %12s %12s %14s\n", "strategy", "messages/s", "allocs/msg", "bytes/msg", "heap/conn")
+	for _, r := range results {
+		fmt.Printf("%-12s %12.0f %12.1f %12.0f %14.0f\n",
+			r.strategy,
+			float64(r.messages)/r.elapsed.Seconds(),
+			float64(r.allocs)/float64(r.messages),
+			float64(r.allocBytes)/float64(r.messages),
+			r.heapPerConn)
+	}
+}
+
+func run(name string, handler echoserver.Handler, cfg config) (result, error) {
+	r := result{strategy: name, messages: cfg.clients * cfg.messages}
+	baseline := memStats()
+
+	server := echoserver.New(
+		echoserver.WithAddr("127.0.0.1:0"),
+		echoserver.WithTLSConfig(cfg.serverTLS),
+		echoserver.WithLogger(log.New(io.Discard, "", 0)),
+		echoserver.WithHandler(handler))
+	if err := server.Start(); err != nil {
+		return r, err
+	}
+	addr := server.Addr().String()
+
+	// Connecting concurrently, but not all at once, keeps within the
+	// listen backlog.
+	cons := make([]net.Conn, cfg.clients)
+	errs := make(chan error, cfg.clients)
+	sem := make(chan struct{}, 64)
+	var wg sync.WaitGroup
+	for i := range cons {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			con, err := dial(addr, cfg.clientTLS)
+			if err == nil {
+				// Echoing once has every handler reach its steady state.
+				err = echo(con, make([]byte, cfg.size), make([]byte, cfg.size))
+			}
+			cons[i] = con
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return r, err
+		}
+	}
+
+	connected := memStats()
+	r.heapPerConn = (float64(connected.HeapInuse) - float64(baseline.HeapInuse)) / float64(cfg.clients)
+
+	errs = make(chan error, cfg.clients)
+	start := time.Now()
+	for i := range cons {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- client(&cons[i], addr, cfg)
+		}(i)
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	echoed := memStats()
+	r.allocs = echoed.Mallocs - connected.Mallocs
+	r.allocBytes = echoed.TotalAlloc - connected.TotalAlloc
+
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return r, err
+		}
+	}
+	for _, con := range cons {
+		con.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return r, server.Stop(ctx)
+}
+
+// client echoes the configured number of messages, reconnecting every
+// messagesPerConnection messages.
+func client(con *net.Conn, addr string, cfg config) error {
+	payload := make([]byte, cfg.size)
+	reply := make([]byte, cfg.size)
+	for i := 0; i < cfg.messages; i++ {
+		if cfg.messagesPerConnection > 0 && i > 0 && i%cfg.messagesPerConnection == 0 {
+			(*con).Close()
+			var err error
+			if *con, err = dial(addr, cfg.clientTLS); err != nil {
+				return err
+			}
+		}
+		if err := echo(*con, payload, reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dial(addr string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, config)
+}
+
+func echo(con net.Conn, payload, reply []byte) error {
+	if _, err := con.Write(payload); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(con, reply)
+	return err
+}
+
+func memStats() runtime.MemStats {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m
+}
+
+// generateTLS creates a certificate authority and a server certificate for
+// 127.0.0.1, which clients verify. Client certificates aren't used as they
+// don't affect the server's buffers.
+func generateTLS() (server, client *tls.Config, err error) {
+	ca, err := pki.NewCA(pkix.Name{CommonName: "bufferbench CA"}, time.Hour)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := ca.IssueServer(pkix.Name{CommonName: "bufferbench"}, []string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := c.TLSCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}},
+		&tls.Config{RootCAs: ca.Pool(), ClientSessionCache: tls.NewLRUClientSessionCache(1)},
+		nil
+}
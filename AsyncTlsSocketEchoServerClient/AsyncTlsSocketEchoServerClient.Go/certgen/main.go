@@ -0,0 +1,121 @@
+package main
+
+// certgen creates a root certificate authority and a server and client
+// certificate signed by it. Unlike makeCerts.sh, it doesn't require OpenSSL
+// and the server certificate includes the SANs the client verifies.
+//
+// With -revoke, it instead uses the existing certificate authority in -dir
+// to create a certificate revocation list revoking the given certificates.
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/clientauth"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory to write certificates and keys to")
+	organization := flag.String("organization", "Bugfree Consulting", "organization of all certificates")
+	caName := flag.String("caName", "ca.bugfree.dk", "common name of the root certificate authority")
+	serverName := flag.String("serverName", "server.bugfree.dk", "common name of the server certificate")
+	serverHosts := flag.String("serverHosts", "server.bugfree.dk,localhost,127.0.0.1,::1", "comma separated host names and IP addresses of the server")
+	clientName := flag.String("clientName", "client.bugfree.dk", "common name of the client certificate")
+	clientHosts := flag.String("clientHosts", "client.bugfree.dk", "comma separated host names and IP addresses of the client")
+	validFor := flag.Duration("validFor", 10*365*24*time.Hour, "how long certificates are valid")
+	revoke := flag.String("revoke", "", "comma separated certificate files to revoke")
+	crlValidFor := flag.Duration("crlValidFor", 7*24*time.Hour, "how long the certificate revocation list is valid")
+	flag.Parse()
+
+	if *revoke != "" {
+		writeCRL(*dir, *caName, strings.Split(*revoke, ","), *crlValidFor)
+		return
+	}
+
+	subject := func(commonName string) pkix.Name {
+		return pkix.Name{
+			Country:      []string{"DK"},
+			Province:     []string{"Sjaelland"},
+			Organization: []string{*organization},
+			CommonName:   commonName,
+		}
+	}
+	hosts := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, ",")
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("unable to create directory: %s", err)
+	}
+	write := func(c *pki.Certificate, name string) {
+		certFile := filepath.Join(*dir, name+".crt")
+		keyFile := filepath.Join(*dir, name+".key")
+		if err := c.WriteFiles(certFile, keyFile); err != nil {
+			log.Fatalf("unable to write %s: %s", name, err)
+		}
+		log.Printf("Wrote %s and %s (SPKI %s)", certFile, keyFile, clientauth.Pin(c.Cert))
+	}
+
+	ca, err := pki.NewCA(subject(*caName), *validFor)
+	if err != nil {
+		log.Fatalf("unable to create certificate authority: %s", err)
+	}
+	write(&ca.Certificate, *caName)
+
+	server, err := ca.IssueServer(subject(*serverName), hosts(*serverHosts), *validFor)
+	if err != nil {
+		log.Fatalf("unable to create server certificate: %s", err)
+	}
+	write(server, *serverName)
+
+	client, err := ca.IssueClient(subject(*clientName), hosts(*clientHosts), *validFor)
+	if err != nil {
+		log.Fatalf("unable to create client certificate: %s", err)
+	}
+	write(client, *clientName)
+}
+
+func writeCRL(dir, caName string, certFiles []string, validFor time.Duration) {
+	ca, err := pki.LoadCA(filepath.Join(dir, caName+".crt"), filepath.Join(dir, caName+".key"))
+	if err != nil {
+		log.Fatalf("unable to load certificate authority: %s", err)
+	}
+
+	var revoked []*x509.Certificate
+	for _, f := range certFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatalf("unable to read certificate: %s", err)
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			log.Fatalf("certificate must be PEM encoded: %s", f)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Fatalf("unable to parse certificate %s: %s", f, err)
+		}
+		revoked = append(revoked, cert)
+	}
+
+	crl, err := ca.CreateCRL(revoked, validFor)
+	if err != nil {
+		log.Fatalf("unable to create certificate revocation list: %s", err)
+	}
+	crlFile := filepath.Join(dir, caName+".crl")
+	if err := os.WriteFile(crlFile, crl, 0644); err != nil {
+		log.Fatalf("unable to write certificate revocation list: %s", err)
+	}
+	log.Printf("Wrote %s revoking %d certificates", crlFile, len(revoked))
+}
@@ -0,0 +1,178 @@
+// Package certwatch keeps a certificate, its key, and a root certificate
+// authority loaded from files and reloads them when the files change. This
+// way certificates can be rotated without restarting the server or client.
+// Existing connections are unaffected while new handshakes use the rotated
+// certificates.
+package certwatch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// material is replaced as a whole so a handshake never sees a certificate
+// from one rotation and a CA from another.
+type material struct {
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	versions []fileVersion
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+type Watcher struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *log.Logger
+	current  atomic.Pointer[material]
+}
+
+// New loads the certificate, key, and CA files. It fails if any of them
+// can't be loaded. The certificate and key, or the CA, may be empty, e.g.,
+// for a client which only verifies the server.
+func New(certFile, keyFile, caFile string, logger *log.Logger) (*Watcher, error) {
+	w := &Watcher{certFile: certFile, keyFile: keyFile, caFile: caFile, logger: logger}
+	m, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.current.Store(m)
+	return w, nil
+}
+
+func (w *Watcher) load() (*material, error) {
+	versions, err := w.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &material{versions: versions}
+	if w.certFile != "" || w.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(w.certFile, w.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate: %w", err)
+		}
+		m.cert = &cert
+	}
+	if w.caFile != "" {
+		ca, err := os.ReadFile(w.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate: %w", err)
+		}
+		m.pool = x509.NewCertPool()
+		if !m.pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in root certificate file: %s", w.caFile)
+		}
+	}
+	return m, nil
+}
+
+func (w *Watcher) versions() ([]fileVersion, error) {
+	var versions []fileVersion
+	for _, f := range []string{w.certFile, w.keyFile, w.caFile} {
+		if f == "" {
+			versions = append(versions, fileVersion{})
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, fileVersion{fi.ModTime(), fi.Size()})
+	}
+	return versions, nil
+}
+
+// Reload loads the files again if any of them changed since they were last
+// loaded. On failure, e.g., because the certificate has been replaced but
+// the key hasn't yet, the previous certificates remain in use.
+func (w *Watcher) Reload() error {
+	versions, err := w.versions()
+	if err != nil {
+		return err
+	}
+	old := w.current.Load()
+	changed := false
+	for i := range versions {
+		if versions[i] != old.versions[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	m, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.current.Store(m)
+	var files []string
+	for _, f := range []string{w.certFile, w.keyFile, w.caFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	w.logger.Printf("Reloaded %s", strings.Join(files, ", "))
+	return nil
+}
+
+// Watch checks for changed files every interval until ctx is done.
+func (w *Watcher) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Reload(); err != nil {
+				w.logger.Printf("Unable to reload certificates, keeping previous: %s", err)
+			}
+		}
+	}
+}
+
+// ServerConfig returns a copy of base which presents the current
+// certificate and verifies clients against the current CA on each
+// handshake.
+func (w *Watcher) ServerConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m := w.current.Load()
+		c := base.Clone()
+		if m.cert != nil {
+			c.Certificates = []tls.Certificate{*m.cert}
+		}
+		if m.pool != nil {
+			c.ClientCAs = m.pool
+		}
+		return c, nil
+	}
+	return config
+}
+
+// ClientConfig returns a copy of base with the current certificate and CA.
+// Call it for each connection to pick up rotated certificates.
+func (w *Watcher) ClientConfig(base *tls.Config) *tls.Config {
+	m := w.current.Load()
+	config := base.Clone()
+	if m.cert != nil {
+		config.Certificates = []tls.Certificate{*m.cert}
+	}
+	if m.pool != nil {
+		config.RootCAs = m.pool
+	}
+	return config
+}
@@ -0,0 +1,267 @@
+package certwatch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+)
+
+// generation is one rotation's certificate authority and the server and
+// client certificates it issued.
+type generation struct {
+	ca     *pki.CA
+	server *pki.Certificate
+	client *pki.Certificate
+}
+
+func newGeneration(t *testing.T, name string) generation {
+	t.Helper()
+	ca, err := pki.NewCA(pkix.Name{CommonName: name + " CA"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ca.IssueServer(pkix.Name{CommonName: name + " server"}, []string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ca.IssueClient(pkix.Name{CommonName: name + " client"}, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return generation{ca, server, client}
+}
+
+type files struct {
+	cert, key, ca string
+}
+
+// write replaces the files with the generation's server certificate, key,
+// and CA, as a rotation would. The modification time is moved forward, as
+// some file systems only keep it to the second.
+func (g generation) write(t *testing.T, f files, modTime time.Time) {
+	t.Helper()
+	if err := g.server.WriteFiles(f.cert, f.key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.ca, g.ca.CertPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{f.cert, f.key, f.ca} {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (g generation) clientConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	cert, err := g.client.TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: g.ca.Pool(), ServerName: "127.0.0.1"}
+}
+
+// serve echoes on an ephemeral port with config until the test completes.
+func serve(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp4", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lock sync.Mutex
+		cons []net.Conn
+	)
+	t.Cleanup(func() {
+		listener.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, con := range cons {
+			con.Close()
+		}
+	})
+	go func() {
+		for {
+			con, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			cons = append(cons, con)
+			lock.Unlock()
+			go io.Copy(con, con)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dial connects, checks that the server presented the certificate of want,
+// and that the connection echoes.
+func dial(t *testing.T, addr string, config *tls.Config, want generation) *tls.Conn {
+	t.Helper()
+	con, err := tls.Dial("tcp4", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { con.Close() })
+	if got := con.ConnectionState().PeerCertificates[0]; !got.Equal(want.server.Cert) {
+		t.Fatalf("server presented %s, want %s", got.Subject, want.server.Cert.Subject)
+	}
+	echo(t, con)
+	return con
+}
+
+func echo(t *testing.T, con *tls.Conn) {
+	t.Helper()
+	con.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := con.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(con, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Fatalf("got %q", b)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	f := files{filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")}
+	first, second := newGeneration(t, "first"), newGeneration(t, "second")
+	now := time.Now()
+	first.write(t, f, now)
+
+	var logs bytes.Buffer
+	w, err := New(f.cert, f.key, f.ca, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, w.ServerConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}))
+	existing := dial(t, addr, first.clientConfig(t), first)
+
+	if err := w.Reload(); err != nil || logs.Len() != 0 {
+		t.Fatalf("reloaded unchanged files: %v %s", err, logs.String())
+	}
+
+	second.write(t, f, now.Add(time.Second))
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Reloaded " + strings.Join([]string{f.cert, f.key, f.ca}, ", ") + "\n"; logs.String() != want {
+		t.Fatalf("got log %q, want %q", logs.String(), want)
+	}
+
+	// New handshakes see the second generation, so clients of the first
+	// neither trust the server nor are trusted by it.
+	dial(t, addr, second.clientConfig(t), second)
+	if con, err := tls.Dial("tcp4", addr, first.clientConfig(t)); err == nil {
+		con.Close()
+		t.Fatal("client of the first generation connected after rotation")
+	}
+	// While the connection from before stays up.
+	echo(t, existing)
+}
+
+func TestReloadKeepsPreviousOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	f := files{filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")}
+	first, second := newGeneration(t, "first"), newGeneration(t, "second")
+	now := time.Now()
+	first.write(t, f, now)
+
+	w, err := New(f.cert, f.key, f.ca, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, w.ServerConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}))
+
+	// The certificate is replaced, but not yet its key.
+	if err := os.WriteFile(f.cert, second.server.CertPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f.cert, now.Add(time.Second), now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil {
+		t.Fatal("loaded a certificate with another's key")
+	}
+	dial(t, addr, first.clientConfig(t), first)
+
+	// Once the key follows, the next reload picks up both.
+	second.write(t, f, now.Add(2*time.Second))
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	dial(t, addr, second.clientConfig(t), second)
+}
+
+func TestClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	f := files{filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt")}
+	first, second := newGeneration(t, "first"), newGeneration(t, "second")
+	now := time.Now()
+	first.write(t, f, now)
+
+	w, err := New(f.cert, f.key, f.ca, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := w.ClientConfig(&tls.Config{ServerName: "127.0.0.1"})
+	second.write(t, f, now.Add(time.Second))
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	after := w.ClientConfig(&tls.Config{ServerName: "127.0.0.1"})
+
+	if !bytes.Equal(before.Certificates[0].Certificate[0], first.server.Cert.Raw) ||
+		!bytes.Equal(after.Certificates[0].Certificate[0], second.server.Cert.Raw) {
+		t.Fatal("client configuration didn't pick up the rotated certificate")
+	}
+	if before.ServerName != "127.0.0.1" || after.RootCAs.Equal(before.RootCAs) {
+		t.Fatal("client configuration didn't pick up the rotated CA")
+	}
+}
+
+func TestNewFails(t *testing.T) {
+	dir := t.TempDir()
+	f := files{filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")}
+	newGeneration(t, "first").write(t, f, time.Now())
+	notPEM := filepath.Join(dir, "garbage")
+	if err := os.WriteFile(notPEM, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		cert, key, caFile string
+	}{
+		{"missing certificate", filepath.Join(dir, "missing"), f.key, f.ca},
+		{"missing CA", f.cert, f.key, filepath.Join(dir, "missing")},
+		{"key without certificate", "", f.key, f.ca},
+		{"CA without certificates", f.cert, f.key, notPEM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cert, tt.key, tt.caFile, log.New(io.Discard, "", 0)); err == nil {
+				t.Fatal("got watcher, want error")
+			}
+		})
+	}
+	if _, err := New("", "", f.ca, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("CA only: %s", err)
+	}
+}
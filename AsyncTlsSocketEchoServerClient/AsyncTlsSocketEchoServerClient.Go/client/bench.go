@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/framing"
+)
+
+type benchConfig struct {
+	duration time.Duration
+	requests uint64
+	rate     float64
+	sizes    sizeDistribution
+	rampUp   time.Duration
+}
+
+// sizeDistribution picks payload sizes. It's parsed from flags of the form
+// "fixed:N", "uniform:MIN-MAX", or "exponential:MEAN".
+type sizeDistribution struct {
+	kind     string
+	min, max int
+	mean     float64
+}
+
+func parseSizeDistribution(s string) (sizeDistribution, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	d := sizeDistribution{kind: kind}
+	var err error
+	switch kind {
+	case "fixed":
+		d.min, err = strconv.Atoi(arg)
+		d.max = d.min
+	case "uniform":
+		lo, hi, ok := strings.Cut(arg, "-")
+		if !ok {
+			return d, fmt.Errorf("uniform sizes must be MIN-MAX: %s", arg)
+		}
+		if d.min, err = strconv.Atoi(lo); err == nil {
+			d.max, err = strconv.Atoi(hi)
+		}
+	case "exponential":
+		d.mean, err = strconv.ParseFloat(arg, 64)
+		d.max = maxFrameSize
+	default:
+		return d, fmt.Errorf("unknown size distribution: %s", kind)
+	}
+	if err != nil {
+		return d, fmt.Errorf("invalid %s sizes: %s", kind, arg)
+	}
+	if d.min < 0 || d.max < d.min || d.max > maxFrameSize || d.mean < 0 {
+		return d, fmt.Errorf("sizes must be between 0 and %d bytes: %s", maxFrameSize, s)
+	}
+	return d, nil
+}
+
+func (d sizeDistribution) next(r *rand.Rand) int {
+	switch d.kind {
+	case "uniform":
+		return d.min + r.Intn(d.max-d.min+1)
+	case "exponential":
+		return min(int(r.ExpFloat64()*d.mean), d.max)
+	default:
+		return d.min
+	}
+}
+
+// benchResult is what a single client measured.
+type benchResult struct {
+	messages   uint64
+	bytes      uint64
+	errors     uint64
+	mismatches uint64
+	latency    histogram
+}
+
+type latencyReport struct {
+	P50 float64 `json:"p50Ms"`
+	P90 float64 `json:"p90Ms"`
+	P99 float64 `json:"p99Ms"`
+	Max float64 `json:"maxMs"`
+}
+
+type handshakeReport struct {
+	Full    uint64        `json:"full"`
+	Resumed uint64        `json:"resumed"`
+	Latency latencyReport `json:"latency"`
+}
+
+type connectionReport struct {
+	Connected    uint64 `json:"connected"`
+	Disconnected uint64 `json:"disconnected"`
+	Reconnecting uint64 `json:"reconnecting"`
+	Failed       uint64 `json:"failed"`
+}
+
+type benchReport struct {
+	Clients           int              `json:"clients"`
+	DurationSeconds   float64          `json:"durationSeconds"`
+	Messages          uint64           `json:"messages"`
+	Bytes             uint64           `json:"bytes"`
+	MessagesPerSecond float64          `json:"messagesPerSecond"`
+	BytesPerSecond    float64          `json:"bytesPerSecond"`
+	Errors            uint64           `json:"errors"`
+	Mismatches        uint64           `json:"mismatches"`
+	Latency           latencyReport    `json:"latency"`
+	Connections       connectionReport `json:"connections"`
+	// Nil for plain TCP.
+	Handshakes *handshakeReport `json:"handshakes,omitempty"`
+}
+
+// bench sends framed payloads until ctx is done or the shared request budget
+// is spent, reconnecting when the connection fails. With a rate, the client
+// waits for its share of the rate between messages. Latency is measured from
+// just before writing a payload until its echo has been read.
+func (c *client) bench(ctx context.Context, config benchConfig, interval time.Duration, sent *atomic.Uint64, seed int64) benchResult {
+	var result benchResult
+	r := rand.New(rand.NewSource(seed))
+	buf := make([]byte, config.sizes.max)
+	r.Read(buf)
+
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	for {
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return result
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			return result
+		}
+		if config.requests > 0 && sent.Add(1) > config.requests {
+			return result
+		}
+
+		payload := buf[:config.sizes.next(r)]
+		start := time.Now()
+		err := framing.Write(c.con, payload, maxFrameSize)
+		var echo []byte
+		if err == nil {
+			echo, err = framing.Read(c.con, maxFrameSize)
+		}
+		if err != nil {
+			// Failing because ctx closed the connection isn't an error.
+			if ctx.Err() != nil {
+				return result
+			}
+			result.errors++
+			if c.reconnect(ctx, err) != nil {
+				return result
+			}
+			continue
+		}
+
+		result.latency.record(time.Since(start))
+		result.messages++
+		result.bytes += uint64(len(payload))
+		if !bytes.Equal(payload, echo) {
+			result.mismatches++
+		}
+	}
+}
+
+// runBench connects the clients, spread over the ramp-up period, and has
+// them send payloads. Ramping up is part of the duration.
+func runBench(clients []*client, config benchConfig) benchReport {
+	ctx := context.Background()
+	if config.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.duration)
+		defer cancel()
+	}
+
+	// The target rate is shared evenly between clients.
+	var interval time.Duration
+	if config.rate > 0 {
+		interval = time.Duration(float64(len(clients)) / config.rate * float64(time.Second))
+	}
+
+	var (
+		sent    atomic.Uint64
+		wg      sync.WaitGroup
+		results = make([]benchResult, len(clients))
+	)
+	// With TLS 1.3, session tickets arrive after the handshake and are only
+	// processed once something is read, so the first client echoes an empty
+	// frame before the others connect for them to be able to resume.
+	ready := make(chan struct{})
+	start := time.Now()
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := clients[i]
+			if i == 0 {
+				err := c.connect(ctx)
+				if err == nil {
+					// A failed echo is left for bench to reconnect.
+					c.roundTrip()
+				}
+				close(ready)
+				if err != nil {
+					return
+				}
+			} else {
+				select {
+				case <-ready:
+				case <-ctx.Done():
+					return
+				}
+				if sleep(ctx, time.Until(start.Add(rampUp(config.rampUp, i, len(clients))))) != nil || c.connect(ctx) != nil {
+					return
+				}
+			}
+			results[i] = c.bench(ctx, config, interval, &sent, start.UnixNano()+int64(i))
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var total benchResult
+	for i := range results {
+		total.messages += results[i].messages
+		total.bytes += results[i].bytes
+		total.errors += results[i].errors
+		total.mismatches += results[i].mismatches
+		total.latency.merge(&results[i].latency)
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	latency := func(h *histogram) latencyReport {
+		return latencyReport{
+			P50: ms(h.percentile(0.50)),
+			P90: ms(h.percentile(0.90)),
+			P99: ms(h.percentile(0.99)),
+			Max: ms(h.max),
+		}
+	}
+
+	var (
+		handshakes       *handshakeReport
+		handshakeLatency histogram
+	)
+	for _, c := range clients {
+		if c.fullHandshakes+c.resumedHandshakes == 0 {
+			continue
+		}
+		if handshakes == nil {
+			handshakes = &handshakeReport{}
+		}
+		handshakes.Full += c.fullHandshakes
+		handshakes.Resumed += c.resumedHandshakes
+		handshakeLatency.merge(&c.handshakes)
+	}
+	if handshakes != nil {
+		handshakes.Latency = latency(&handshakeLatency)
+	}
+
+	return benchReport{
+		Clients:           len(clients),
+		DurationSeconds:   elapsed.Seconds(),
+		Messages:          total.messages,
+		Bytes:             total.bytes,
+		MessagesPerSecond: float64(total.messages) / elapsed.Seconds(),
+		BytesPerSecond:    float64(total.bytes) / elapsed.Seconds(),
+		Errors:            total.errors,
+		Mismatches:        total.mismatches,
+		Latency:           latency(&total.latency),
+		Connections: connectionReport{
+			Connected:    connected.Load(),
+			Disconnected: disconnected.Load(),
+			Reconnecting: reconnecting.Load(),
+			Failed:       failed.Load(),
+		},
+		Handshakes: handshakes,
+	}
+}
+
+func (r benchReport) log() {
+	log.Printf("Clients: %d", r.Clients)
+	log.Printf("Duration: %.2fs", r.DurationSeconds)
+	log.Printf("Messages: %d (%.0f/s)", r.Messages, r.MessagesPerSecond)
+	log.Printf("Bytes: %d (%.0f/s)", r.Bytes, r.BytesPerSecond)
+	log.Printf("Errors: %d", r.Errors)
+	log.Printf("Mismatches: %d", r.Mismatches)
+	log.Printf("Latency: p50 %.3fms, p90 %.3fms, p99 %.3fms, max %.3fms",
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	log.Printf("Connected: %d, disconnected: %d, reconnecting: %d, failed to connect: %d",
+		r.Connections.Connected, r.Connections.Disconnected, r.Connections.Reconnecting, r.Connections.Failed)
+	if h := r.Handshakes; h != nil {
+		log.Printf("Handshakes: %d full, %d resumed", h.Full, h.Resumed)
+		log.Printf("Handshake latency: p50 %.3fms, p90 %.3fms, p99 %.3fms, max %.3fms",
+			h.Latency.P50, h.Latency.P90, h.Latency.P99, h.Latency.Max)
+	}
+}
+
+// writeJSON writes the report to path, or to stdout if path is "-".
+func (r benchReport) writeJSON(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
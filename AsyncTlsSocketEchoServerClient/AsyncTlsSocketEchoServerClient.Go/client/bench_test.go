@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBenchEndsWithStalledServer(t *testing.T) {
+	// Accepts connections but never reads from or writes to them.
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var cons []net.Conn
+		defer func() {
+			for _, con := range cons {
+				con.Close()
+			}
+		}()
+		for {
+			con, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cons = append(cons, con)
+		}
+	}()
+
+	clients := make([]*client, 4)
+	for i := range clients {
+		clients[i] = newClient(l.Addr().String(), &net.Dialer{}, func() *tls.Config { return nil }, "", time.Millisecond, time.Millisecond, int64(i))
+	}
+	sizes, err := parseSizeDistribution("fixed:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan benchReport)
+	go func() { done <- runBench(clients, benchConfig{duration: 100 * time.Millisecond, sizes: sizes}) }()
+	select {
+	case r := <-done:
+		if r.Messages != 0 || r.Errors != 0 {
+			t.Fatalf("got %d messages and %d errors, want none", r.Messages, r.Errors)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("benchmark didn't end with its duration")
+	}
+}
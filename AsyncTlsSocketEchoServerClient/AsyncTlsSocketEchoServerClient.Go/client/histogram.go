@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of buckets per power of two. Each bucket is at
+// most 1/16th of its value wide, so percentiles are within about 6% while
+// memory use doesn't grow with the number of samples.
+const subBuckets = 16
+
+// histogram records latencies with microsecond resolution.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	max    time.Duration
+}
+
+func bucketOf(d time.Duration) int {
+	v := uint64(d / time.Microsecond)
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 5
+	return exp*subBuckets + int(v>>exp)
+}
+
+// upperBound is the largest value in microseconds that falls in bucket i.
+func upperBound(i int) uint64 {
+	exp := 0
+	if i >= 2*subBuckets {
+		exp = i/subBuckets - 1
+	}
+	m := uint64(i - exp*subBuckets)
+	return (m+1)<<exp - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := bucketOf(d)
+	for len(h.counts) <= i {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *histogram) merge(other *histogram) {
+	for len(h.counts) < len(other.counts) {
+		h.counts = append(h.counts, 0)
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// percentile returns the latency below which q (between 0 and 1) of the
+// samples fall.
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	// The rank is at least one, so q of zero is the smallest sample.
+	rank := max(uint64(math.Ceil(q*float64(h.total))), 1)
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			d := time.Duration(upperBound(i)) * time.Microsecond
+			if d > h.max {
+				return h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	repeat := func(d time.Duration, n int) []time.Duration {
+		samples := make([]time.Duration, n)
+		for i := range samples {
+			samples[i] = d
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		q       float64
+		want    time.Duration
+	}{
+		{"empty", nil, 0.5, 0},
+		{"empty max", nil, 1, 0},
+		{"single p0", []time.Duration{5 * time.Millisecond}, 0, 5 * time.Millisecond},
+		{"single p50", []time.Duration{5 * time.Millisecond}, 0.5, 5 * time.Millisecond},
+		{"single p100", []time.Duration{5 * time.Millisecond}, 1, 5 * time.Millisecond},
+		{"below bucket resolution", []time.Duration{7 * time.Microsecond}, 0.5, 7 * time.Microsecond},
+		{"skewed p50", append(repeat(time.Millisecond, 99), time.Second), 0.5, time.Millisecond},
+		{"skewed p99", append(repeat(time.Millisecond, 99), time.Second), 0.99, time.Millisecond},
+		{"skewed p999", append(repeat(time.Millisecond, 99), time.Second), 0.999, time.Second},
+		{"skewed p100", append(repeat(time.Millisecond, 99), time.Second), 1, time.Second},
+		{"skewed low p50", append([]time.Duration{time.Microsecond}, repeat(time.Second, 9)...), 0.5, time.Second},
+		{"skewed low p10", append([]time.Duration{time.Microsecond}, repeat(time.Second, 9)...), 0.1, time.Microsecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h histogram
+			for _, d := range tt.samples {
+				h.record(d)
+			}
+			// Buckets are at most 1/16th of their value wide and percentiles
+			// report a bucket's upper bound.
+			got := h.percentile(tt.q)
+			if got < tt.want || got > tt.want+tt.want/subBuckets {
+				t.Fatalf("got %s, want %s within 1/%d", got, tt.want, subBuckets)
+			}
+		})
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	// Every value falls in a bucket whose upper bound is at least the value
+	// and less than 1/16th above it.
+	for v := uint64(0); v < 1<<20; v += 1 + v/7 {
+		i := bucketOf(time.Duration(v) * time.Microsecond)
+		if upper := upperBound(i); upper < v || upper > v+v/subBuckets {
+			t.Fatalf("%dµs in bucket %d with upper bound %dµs", v, i, upper)
+		}
+		if i > 0 && upperBound(i-1) >= v {
+			t.Fatalf("%dµs in bucket %d, but bucket %d goes up to %dµs", v, i, i-1, upperBound(i-1))
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b histogram
+	a.record(time.Millisecond)
+	b.record(time.Second)
+	b.record(time.Second)
+	a.merge(&b)
+
+	if a.total != 3 || a.max != time.Second {
+		t.Fatalf("got %d samples and max %s, want 3 and 1s", a.total, a.max)
+	}
+	if got := a.percentile(0.3); got < time.Millisecond || got > time.Millisecond+time.Millisecond/subBuckets {
+		t.Fatalf("got p30 %s, want 1ms", got)
+	}
+
+	// Merging into an empty histogram grows its buckets.
+	var empty histogram
+	empty.merge(&a)
+	if empty.total != 3 || empty.percentile(1) != time.Second {
+		t.Fatalf("got %d samples and p100 %s, want 3 and 1s", empty.total, empty.percentile(1))
+	}
+}
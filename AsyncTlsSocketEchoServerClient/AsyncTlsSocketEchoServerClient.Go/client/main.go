@@ -1,115 +1,290 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"flag"
-	"io"
-	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
 	"os"
-	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/certwatch"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/framing"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/tlsconfig"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/websocket"
 )
 
-const maxRandomDataSize = 100
+const (
+	maxRandomDataSize = 100
+	maxFrameSize      = 64 * 1024
+)
 
 var randomData = make([][]byte, 100)
 
+// Totals across clients.
+var (
+	messages   atomic.Uint64
+	mismatches atomic.Uint64
+)
+
 type client struct {
-	con *tls.Conn
+	con       net.Conn
+	endpoint  string
+	dialer    *net.Dialer
+	newConfig func() *tls.Config // Returns nil for plain TCP.
+	websocket string             // Path of the server's WebSocket gateway, if used.
+	backoff   backoff
+	// unbind stops the connection from being closed when the context it was
+	// dialed with is done.
+	unbind func() bool
+
+	// Across the client's TLS connections.
+	fullHandshakes    uint64
+	resumedHandshakes uint64
+	handshakes        histogram
 }
 
-func (c *client) connect(endpoint string, config *tls.Config) {
-	//defer conn.Close()
-	var err error
-	c.con, err = tls.Dial("tcp4", endpoint, config)
+func newClient(endpoint string, dialer *net.Dialer, newConfig func() *tls.Config, websocketPath string, initialBackoff, maxBackoff time.Duration, seed int64) *client {
+	return &client{
+		endpoint:  endpoint,
+		dialer:    dialer,
+		newConfig: newConfig,
+		websocket: websocketPath,
+		backoff:   backoff{initial: initialBackoff, max: maxBackoff, rand: rand.New(rand.NewSource(seed))},
+	}
+}
+
+// dial makes a single attempt at connecting over TLS, or plain TCP, and
+// then WebSocket if used. The TLS handshake is timed separately from
+// establishing the TCP connection. The connection is closed when ctx is
+// done, so reads and writes blocked on a stalled server return.
+func (c *client) dial(ctx context.Context) error {
+	con, err := c.dialer.DialContext(ctx, "tcp4", c.endpoint)
 	if err != nil {
-		log.Fatalf("Unable to connect to server: %s", err)
+		return err
+	}
+	unbind := context.AfterFunc(ctx, func() { con.Close() })
+	if err := c.handshake(ctx, con); err != nil {
+		unbind()
+		return err
+	}
+	c.unbind = unbind
+	return nil
+}
+
+// handshake completes the TLS handshake, if used, and the WebSocket opening
+// handshake, if used, over con. It closes con on failure.
+func (c *client) handshake(ctx context.Context, con net.Conn) error {
+	log.Printf("Connected to server: %s", con.LocalAddr())
+	config := c.newConfig()
+	if config == nil {
+		c.con = con
+		return c.upgrade()
 	}
 
-	c.con.Handshake()
-	log.Printf("Connected to server: %s", c.con.LocalAddr())
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(c.endpoint)
+	}
+	tlsCon := tls.Client(con, config)
+	start := time.Now()
+	if err := tlsCon.HandshakeContext(ctx); err != nil {
+		con.Close()
+		return err
+	}
+	took := time.Since(start)
+	c.con = tlsCon
 
-	state := c.con.ConnectionState()
+	state := tlsCon.ConnectionState()
+	c.handshakes.record(took)
+	if state.DidResume {
+		c.resumedHandshakes++
+	} else {
+		c.fullHandshakes++
+	}
 	for _, v := range state.PeerCertificates {
 		log.Printf("PeerCertificate: %v", v.Subject)
 	}
-	log.Println("HandshakeComplete: ", state.HandshakeComplete)
-	log.Println("NegotiatedProtocolIsMutual: ", state.NegotiatedProtocolIsMutual)
+	log.Printf("Handshake complete: %s, protocol %q, resumed: %v, took %s",
+		tls.VersionName(state.Version), state.NegotiatedProtocol, state.DidResume, took)
+	return c.upgrade()
 }
 
-func (c client) disconnect(endpoint string) {
+// upgrade switches the connection to WebSocket if used.
+func (c *client) upgrade() error {
+	if c.websocket == "" {
+		return nil
+	}
+	ws, err := websocket.Client(c.con, c.endpoint, c.websocket)
+	if err != nil {
+		c.con.Close()
+		return err
+	}
+	c.con = ws
+	return nil
+}
 
+// roundTrip echoes an empty frame.
+func (c *client) roundTrip() error {
+	if err := framing.Write(c.con, nil, maxFrameSize); err != nil {
+		return err
+	}
+	_, err := framing.Read(c.con, maxFrameSize)
+	return err
 }
 
-func (c client) echo() {
+// echo sends random payloads and verifies that what comes back is
+// byte-for-byte what was sent, reconnecting when the connection fails. It
+// works with the server's echo and frame handlers since both return frames
+// unchanged.
+func (c *client) echo(ctx context.Context) {
 	for {
-		i := rand.Intn(100)
-		n, err := c.con.Write(randomData[i])
+		payload := randomData[rand.Intn(maxRandomDataSize)]
+		err := framing.Write(c.con, payload, maxFrameSize)
+		var echo []byte
+		if err == nil {
+			echo, err = framing.Read(c.con, maxFrameSize)
+		}
 		if err != nil {
-			if strings.Contains(err.Error(), "write: broken pipe") {
+			if ctx.Err() != nil || c.reconnect(ctx, err) != nil {
 				return
 			}
-			log.Panicf("%d %s", n, err)
+			continue
 		}
 
-		m, err := c.con.Read(randomData[i])
-		if err != nil {
-			if err == io.EOF {
-				return
-			}
-			log.Panicf("%d %s", m, err)
+		messages.Add(1)
+		if !bytes.Equal(payload, echo) {
+			mismatches.Add(1)
 		}
 	}
 }
 
 func main() {
+	modeName := flag.String("mode", "mtls", "tcp, tls for server authentication, or mtls for mutual authentication")
+	minVersion := flag.String("minVersion", "1.2", "minimum TLS version: 1.0, 1.1, 1.2, or 1.3")
+	maxVersion := flag.String("maxVersion", "", "maximum TLS version, or empty for the highest supported")
+	cipherSuites := flag.String("cipherSuites", "", "comma separated TLS 1.2 cipher suites, or empty for Go's defaults")
+	protocols := flag.String("alpn", "echo/1", "comma separated ALPN protocols in order of preference, or empty to not negotiate")
+	websocketPath := flag.String("websocket", "", "path of the server's WebSocket gateway to connect through, e.g., /echo, or empty to connect directly")
+	sessionCache := flag.Int("sessionCache", 64, "number of TLS sessions to cache for resumption, or 0 to always do full handshakes")
 	rootCAFile := flag.String("rootCA", "", "root certificate authority file")
 	clientCertFile := flag.String("clientCertFile", "", "client certificate file")
 	clientKeyFile := flag.String("clientKeyFile", "", "client key file")
 	serverEndpoint := flag.String("server", "127.0.0.1:8080", "server IP and port number")
+	serverName := flag.String("serverName", "", "name to verify the server certificate against, if other than the host in -server")
 	numClients := flag.Int("clients", 64, "number of clients connecting to the server")
+	bench := flag.Bool("bench", false, "run a benchmark instead of waiting for key presses")
+	duration := flag.Duration("duration", 10*time.Second, "benchmark duration, or 0 for no limit")
+	requests := flag.Uint64("requests", 0, "total number of benchmark requests, or 0 for no limit")
+	rate := flag.Float64("rate", 0, "target benchmark requests per second across clients, or 0 for as fast as possible")
+	sizes := flag.String("sizes", "uniform:0-99", "benchmark payload sizes: fixed:N, uniform:MIN-MAX, or exponential:MEAN")
+	keepAlive := flag.Duration("keepAlive", 0, "interval of TCP keepalive probes, 0 for the operating system's default, or negative to disable")
+	reloadInterval := flag.Duration("reloadInterval", 10*time.Second, "how often to check certificate files for changes, or 0 to never")
+	rampUpPeriod := flag.Duration("rampUp", 0, "period over which to spread initial connections, or 0 to connect all at once")
+	initialBackoff := flag.Duration("backoff", 100*time.Millisecond, "maximum wait before the first attempt at reconnecting, doubling with each failed attempt")
+	maxBackoff := flag.Duration("maxBackoff", 10*time.Second, "maximum wait between attempts at reconnecting")
+	reportFile := flag.String("report", "", "file to write the benchmark report to as JSON, or - for stdout")
 	flag.Parse()
 
-	certPool := x509.NewCertPool()
-	rootCA, err := ioutil.ReadFile(*rootCAFile)
+	sizeDistribution, err := parseSizeDistribution(*sizes)
 	if err != nil {
-		log.Fatalf("failed to load root certificate: %s", *rootCAFile)
+		log.Fatal(err)
 	}
-	ok := certPool.AppendCertsFromPEM(rootCA)
-	if !ok {
-		log.Fatalf("failed to add PEM certificates to cert pool: %s", *rootCAFile)
+	if *bench && *duration == 0 && *requests == 0 {
+		log.Fatal("benchmark needs a duration or number of requests")
 	}
 
-	cert, err := tls.LoadX509KeyPair(*clientCertFile, *clientKeyFile)
+	mode, err := tlsconfig.ParseMode(*modeName)
 	if err != nil {
-		log.Fatalf("unable to load client certificate: %s", err)
+		log.Fatal(err)
+	}
+
+	// Each connection gets the certificates current at the time, or no
+	// config for plain TCP.
+	newConfig := func() *tls.Config { return nil }
+	if mode == tlsconfig.MutualTLS && (*clientCertFile == "" || *clientKeyFile == "") {
+		log.Fatalf("-mode %s needs -clientCertFile and -clientKeyFile", mode)
+	}
+	if mode != tlsconfig.TCP {
+		config := &tls.Config{ServerName: *serverName}
+		if err := tlsconfig.Apply(config, *minVersion, *maxVersion, *cipherSuites, *protocols); err != nil {
+			log.Fatal(err)
+		}
+		// The WebSocket gateway is an HTTP server.
+		if *websocketPath != "" {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		// The cache is shared by every connection, as Clone keeps it.
+		if *sessionCache > 0 {
+			config.ClientSessionCache = tls.NewLRUClientSessionCache(*sessionCache)
+		}
+		certFile, keyFile := "", ""
+		if mode == tlsconfig.MutualTLS {
+			certFile, keyFile = *clientCertFile, *clientKeyFile
+		}
+
+		watcher, err := certwatch.New(certFile, keyFile, *rootCAFile, log.Default())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *reloadInterval > 0 {
+			go watcher.Watch(context.Background(), *reloadInterval)
+		}
+		newConfig = func() *tls.Config { return watcher.ClientConfig(config) }
+	}
+
+	dialer := &net.Dialer{KeepAlive: *keepAlive}
+
+	clients := make([]*client, *numClients)
+	for i := range clients {
+		clients[i] = newClient(*serverEndpoint, dialer, newConfig, *websocketPath, *initialBackoff, *maxBackoff, time.Now().UnixNano()+int64(i))
+	}
+
+	if *bench {
+		report := runBench(clients, benchConfig{
+			duration: *duration,
+			requests: *requests,
+			rate:     *rate,
+			sizes:    sizeDistribution,
+			rampUp:   *rampUpPeriod})
+		report.log()
+		if *reportFile != "" {
+			if err := report.writeJSON(*reportFile); err != nil {
+				log.Fatalf("unable to write report: %s", err)
+			}
+		}
+		return
 	}
-	config := tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true, // set to true or you get "x509: cannot validate certificate for 127.0.0.1 because it doesn't contain any IP SANs"
-		MinVersion:         tls.VersionTLS12,
-		RootCAs:            certPool}
 
 	for i := 0; i < maxRandomDataSize; i++ {
 		randomData[i] = make([]byte, i)
 		rand.Read(randomData[i])
 	}
 
-	clients := make([]client, *numClients)
-	for i := 0; i < *numClients; i++ {
-		clients[i].connect(*serverEndpoint, &config)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	for i, c := range clients {
+		time.Sleep(time.Until(start.Add(rampUp(*rampUpPeriod, i, len(clients)))))
+		c.connect(ctx)
 	}
 
 	b := make([]byte, 1)
 	println("Press any key to start sending and receiving")
 	os.Stdin.Read(b)
 
-	for i := 0; i < *numClients; i++ {
-		go clients[i].echo()
+	for _, c := range clients {
+		go c.echo(ctx)
 	}
 
 	println("Press any key to stop sending and receiving")
 	os.Stdin.Read(b)
+	cancel()
+
+	log.Printf("Messages: %d", messages.Load())
+	log.Printf("Mismatches: %d", mismatches.Load())
+	log.Printf("Connected: %d, disconnected: %d, reconnecting: %d, failed to connect: %d",
+		connected.Load(), disconnected.Load(), reconnecting.Load(), failed.Load())
 }
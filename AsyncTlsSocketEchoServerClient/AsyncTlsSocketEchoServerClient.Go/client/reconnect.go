@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Connection lifecycle events across clients.
+var (
+	connected    atomic.Uint64
+	disconnected atomic.Uint64 // By the server or the network.
+	reconnecting atomic.Uint64
+	failed       atomic.Uint64 // Attempts to connect.
+)
+
+// backoff is exponential with full jitter: the n'th delay is random between
+// zero and initial*2^n, capped at max. The randomness spreads out clients
+// which lost their connections at the same time, e.g., when the server
+// restarted.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt int
+	rand    *rand.Rand
+}
+
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if e := b.initial << b.attempt; e > 0 && e < b.max {
+			d = e
+		}
+	}
+	b.attempt++
+	return time.Duration(b.rand.Int63n(int64(d) + 1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// connect connects, retrying with backoff, until it succeeds or ctx is
+// done.
+func (c *client) connect(ctx context.Context) error {
+	for {
+		err := c.dial(ctx)
+		if err == nil {
+			connected.Add(1)
+			c.backoff.reset()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		failed.Add(1)
+		d := c.backoff.next()
+		log.Printf("Unable to connect to server, retrying in %s: %s", d.Round(time.Millisecond), err)
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect replaces a connection which failed with err. It waits before
+// connecting, so clients disconnected together don't reconnect together.
+func (c *client) reconnect(ctx context.Context, err error) error {
+	disconnected.Add(1)
+	log.Printf("Disconnected from server: %s: %s", c.con.LocalAddr(), err)
+	// It may already have been closed so we ignore any error.
+	c.unbind()
+	c.con.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	reconnecting.Add(1)
+	if err := sleep(ctx, c.backoff.next()); err != nil {
+		return err
+	}
+	return c.connect(ctx)
+}
+
+// rampUp is how long client i of n waits before connecting, to spread
+// connections evenly over period.
+func rampUp(period time.Duration, i, n int) time.Duration {
+	return period * time.Duration(i) / time.Duration(n)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+// Package clientauth decides which clients may connect beyond having a
+// certificate signed by a trusted certificate authority. Certificates are
+// checked against a denylist, an allowlist, and a certificate revocation
+// list, and each rejection is written to an audit log.
+package clientauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrRejected is wrapped by errors returned for clients the policy rejects.
+var ErrRejected = errors.New("client certificate rejected")
+
+type Policy struct {
+	// Deny rejects clients matching any of its rules.
+	Deny []Rule
+	// Allow, unless empty, rejects clients matching none of its rules.
+	Allow []Rule
+	// CRL, unless nil, rejects clients whose certificate it revokes.
+	CRL *x509.RevocationList
+	// Audit receives an entry for each rejected client.
+	Audit *log.Logger
+
+	revoked map[string]bool
+}
+
+// ReadCRL reads a PEM or DER encoded certificate revocation list. Its
+// signature is checked against the issuer of each client certificate it's
+// used for.
+func ReadCRL(path string) (*x509.RevocationList, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	return x509.ParseRevocationList(b)
+}
+
+// Apply returns a copy of config which verifies clients against the policy
+// after the usual verification of their certificate chain. It builds on
+// config's GetConfigForClient, such as the one from certwatch, if any.
+func (p *Policy) Apply(config *tls.Config) *tls.Config {
+	if p.CRL != nil && p.revoked == nil {
+		p.revoked = make(map[string]bool, len(p.CRL.RevokedCertificateEntries))
+		for _, e := range p.CRL.RevokedCertificateEntries {
+			p.revoked[e.SerialNumber.String()] = true
+		}
+	}
+
+	c := config.Clone()
+	inner := config.GetConfigForClient
+	c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		base := config
+		if inner != nil {
+			var err error
+			if base, err = inner(hello); err != nil {
+				return nil, err
+			}
+		}
+		perClient := base.Clone()
+		addr := hello.Conn.RemoteAddr().String()
+		perClient.VerifyConnection = func(cs tls.ConnectionState) error {
+			return p.verify(addr, cs)
+		}
+		return perClient, nil
+	}
+	return c
+}
+
+func (p *Policy) verify(addr string, cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return p.reject(addr, nil, "no verified certificate")
+	}
+	chain := cs.VerifiedChains[0]
+	cert := chain[0]
+
+	for _, r := range p.Deny {
+		if r.matches(cert) {
+			return p.reject(addr, cert, "denied by "+r.String())
+		}
+	}
+	if len(p.Allow) > 0 && !p.allowed(cert) {
+		return p.reject(addr, cert, "not allowed by any rule")
+	}
+	if p.CRL != nil {
+		if reason := p.checkRevocation(chain); reason != "" {
+			return p.reject(addr, cert, reason)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) allowed(cert *x509.Certificate) bool {
+	for _, r := range p.Allow {
+		if r.matches(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRevocation returns why the certificate is rejected, or "" if it isn't.
+// A CRL from a different issuer doesn't apply, while an expired or forged
+// CRL from the same issuer rejects all its certificates.
+func (p *Policy) checkRevocation(chain []*x509.Certificate) string {
+	cert := chain[0]
+	if len(chain) < 2 || string(p.CRL.RawIssuer) != string(cert.RawIssuer) {
+		return ""
+	}
+	if err := p.CRL.CheckSignatureFrom(chain[1]); err != nil {
+		return fmt.Sprintf("invalid CRL: %s", err)
+	}
+	if !p.CRL.NextUpdate.IsZero() && time.Now().After(p.CRL.NextUpdate) {
+		return "CRL expired at " + p.CRL.NextUpdate.Format(time.RFC3339)
+	}
+	if p.revoked[cert.SerialNumber.String()] {
+		return "revoked"
+	}
+	return ""
+}
+
+func (p *Policy) reject(addr string, cert *x509.Certificate, reason string) error {
+	if cert == nil {
+		p.audit("Rejected client %s: %s", addr, reason)
+		return fmt.Errorf("%w: %s", ErrRejected, reason)
+	}
+	p.audit("Rejected client %s: %s (subject %q, SANs %q, serial %s, SPKI %s)",
+		addr, reason, cert.Subject.String(), sans(cert), serial(cert.SerialNumber), Pin(cert))
+	return fmt.Errorf("%w: %s: %s", ErrRejected, cert.Subject, reason)
+}
+
+func (p *Policy) audit(format string, v ...any) {
+	if p.Audit != nil {
+		p.Audit.Printf(format, v...)
+	}
+}
+
+func sans(cert *x509.Certificate) string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func serial(n *big.Int) string {
+	return fmt.Sprintf("%X", n)
+}
@@ -0,0 +1,308 @@
+package clientauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+)
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadRules(t *testing.T) {
+	rules, err := ReadRules(writeFile(t, "rules", []byte(`# Clients of the billing team.
+cn:billing.example.com
+
+  DNS : Billing.Example.com
+subject:CN=billing,O=Example,C=DK
+ip:10.0.0.1
+ip:2001:db8::1
+email:billing@example.com
+uri:spiffe://example.com/billing
+spki:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{"cn", "billing.example.com"},
+		{"dns", "Billing.Example.com"},
+		{"subject", "CN=billing,O=Example,C=DK"},
+		{"ip", "10.0.0.1"},
+		{"ip", "2001:db8::1"},
+		{"email", "billing@example.com"},
+		{"uri", "spiffe://example.com/billing"},
+		{"spki", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("got %v, want %v", rules, want)
+	}
+}
+
+func TestReadRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"no kind", "# Comment\nbilling.example.com\n", ":2: rule must be kind:value"},
+		{"no value", "cn:\n", ":1: rule must be kind:value"},
+		{"unknown kind", "cn:a\nou:billing\n", ":2: unknown rule kind: ou"},
+		{"invalid IP address", "ip:10.0.0\n", ":1: invalid IP address: 10.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "rules", []byte(tt.content))
+			_, err := ReadRules(path)
+			if err == nil || err.Error() != path+tt.err {
+				t.Fatalf("got error %v, want %s%s", err, path, tt.err)
+			}
+		})
+	}
+
+	if _, err := ReadRules(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.com/billing")
+	cert := &x509.Certificate{
+		Subject:                 pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:                []string{"billing.example.com"},
+		IPAddresses:             []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses:          []string{"billing@example.com"},
+		URIs:                    []*url.URL{u},
+		RawSubjectPublicKeyInfo: []byte("key"),
+	}
+
+	tests := []struct {
+		rule Rule
+		want bool
+	}{
+		{Rule{"subject", "CN=billing,O=Example"}, true},
+		{Rule{"subject", "CN=billing"}, false},
+		{Rule{"cn", "billing"}, true},
+		{Rule{"cn", "Billing"}, false},
+		{Rule{"dns", "BILLING.example.com"}, true},
+		{Rule{"dns", "example.com"}, false},
+		{Rule{"ip", "10.0.0.1"}, true},
+		{Rule{"ip", "::ffff:10.0.0.1"}, true},
+		{Rule{"ip", "10.0.0.2"}, false},
+		{Rule{"email", "Billing@Example.com"}, true},
+		{Rule{"email", "sales@example.com"}, false},
+		{Rule{"uri", "spiffe://example.com/billing"}, true},
+		{Rule{"uri", "spiffe://example.com/"}, false},
+		{Rule{"spki", Pin(cert)}, true},
+		{Rule{"spki", Pin(&x509.Certificate{RawSubjectPublicKeyInfo: []byte("other key")})}, false},
+		{Rule{"ou", "billing"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(cert); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+// issued is a client certificate and the chain verifying it.
+type issued struct {
+	cert  *pki.Certificate
+	chain []*x509.Certificate
+}
+
+func newCA(t *testing.T, name string) *pki.CA {
+	t.Helper()
+	ca, err := pki.NewCA(pkix.Name{CommonName: name}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func issue(t *testing.T, ca *pki.CA, name string, hosts ...string) issued {
+	t.Helper()
+	c, err := ca.IssueClient(pkix.Name{CommonName: name}, hosts, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issued{c, []*x509.Certificate{c.Cert, ca.Cert}}
+}
+
+func state(i issued) tls.ConnectionState {
+	return tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{i.chain}}
+}
+
+func TestVerifyRules(t *testing.T) {
+	ca := newCA(t, "test CA")
+	billing := issue(t, ca, "billing")
+	sales := issue(t, ca, "sales")
+	allowBilling := []Rule{{"cn", "billing"}}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		client issued
+		reason string // Empty if allowed.
+	}{
+		{"no rules", Policy{}, billing, ""},
+		{"allowed", Policy{Allow: allowBilling}, billing, ""},
+		{"not allowed", Policy{Allow: allowBilling}, sales, "CN=sales: not allowed by any rule"},
+		{"denied", Policy{Deny: []Rule{{"cn", "sales"}}}, sales, "CN=sales: denied by cn:sales"},
+		{"not denied", Policy{Deny: []Rule{{"cn", "sales"}}}, billing, ""},
+		{"deny before allow", Policy{Allow: allowBilling, Deny: []Rule{{"spki", Pin(billing.cert.Cert)}}}, billing,
+			"CN=billing: denied by spki:" + Pin(billing.cert.Cert)},
+		{"no verified chain", Policy{}, issued{}, "no verified certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := state(tt.client)
+			if tt.client.chain == nil {
+				cs = tls.ConnectionState{}
+			}
+			err := tt.policy.verify("192.0.2.1:1234", cs)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRejected) || err.Error() != ErrRejected.Error()+": "+tt.reason {
+				t.Fatalf("got error %v, want %s", err, tt.reason)
+			}
+		})
+	}
+}
+
+// withCRL returns a policy with the CRL read from PEM, as by -crl.
+func withCRL(t *testing.T, crlPEM []byte) *Policy {
+	t.Helper()
+	crl, err := ReadCRL(writeFile(t, "crl.pem", crlPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Policy{CRL: crl}
+	p.Apply(&tls.Config{})
+	return p
+}
+
+func createCRL(t *testing.T, ca *pki.CA, validFor time.Duration, revoked ...issued) []byte {
+	t.Helper()
+	var certs []*x509.Certificate
+	for _, r := range revoked {
+		certs = append(certs, r.cert.Cert)
+	}
+	crl, err := ca.CreateCRL(certs, validFor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+// expiredCRL is a CRL by ca whose next update was due a minute ago, which
+// CreateCRL doesn't create.
+func expiredCRL(t *testing.T, ca *pki.CA) []byte {
+	t.Helper()
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(-time.Minute),
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newCA(t, "test CA")
+	revoked := issue(t, ca, "revoked")
+	valid := issue(t, ca, "valid")
+	other := newCA(t, "other CA")
+	// Same name as the CA, but a different key.
+	forger := newCA(t, "test CA")
+
+	tests := []struct {
+		name   string
+		crl    []byte
+		chain  []*x509.Certificate
+		reason string // Empty if not rejected.
+	}{
+		{"revoked", createCRL(t, ca, time.Hour, revoked), revoked.chain, "revoked"},
+		{"not revoked", createCRL(t, ca, time.Hour, revoked), valid.chain, ""},
+		{"expired CRL", expiredCRL(t, ca), valid.chain, "CRL expired at "},
+		{"other issuer's CRL", createCRL(t, other, time.Hour, revoked), revoked.chain, ""},
+		{"forged CRL", createCRL(t, forger, time.Hour), valid.chain, "invalid CRL: "},
+		{"no issuer in chain", createCRL(t, ca, time.Hour, revoked), revoked.chain[:1], ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withCRL(t, tt.crl).checkRevocation(tt.chain)
+			if tt.reason == "" && got != "" || !strings.HasPrefix(got, tt.reason) {
+				t.Fatalf("got %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestReadCRLDER(t *testing.T) {
+	ca := newCA(t, "test CA")
+	crl, err := ReadCRL(writeFile(t, "crl.pem", createCRL(t, ca, time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := ReadCRL(writeFile(t, "crl.der", crl.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der.Raw, crl.Raw) {
+		t.Fatal("DER and PEM encoded CRLs differ")
+	}
+	if _, err := ReadCRL(writeFile(t, "garbage", []byte("garbage"))); err == nil {
+		t.Fatal("read garbage as a CRL")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ca := newCA(t, "test CA")
+	revoked := issue(t, ca, "revoked", "revoked.example.com", "10.0.0.1")
+	var b bytes.Buffer
+	p := withCRL(t, createCRL(t, ca, time.Hour, revoked))
+	p.Audit = log.New(&b, "", 0)
+
+	if err := p.verify("192.0.2.1:1234", state(revoked)); err == nil {
+		t.Fatal("revoked client verified")
+	}
+	if err := p.verify("192.0.2.2:1234", tls.ConnectionState{}); err == nil {
+		t.Fatal("client without certificate verified")
+	}
+	want := fmt.Sprintf("Rejected client 192.0.2.1:1234: revoked (subject \"CN=revoked\", SANs \"revoked.example.com,10.0.0.1\", serial %X, SPKI %s)\n"+
+		"Rejected client 192.0.2.2:1234: no verified certificate\n",
+		revoked.cert.Cert.SerialNumber, Pin(revoked.cert.Cert))
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,111 @@
+package clientauth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Rule matches client certificates. Rules are read from files with one rule
+// per line of the form kind:value. Blank lines and lines starting with # are
+// ignored. Kinds are:
+//
+//	subject  the full subject, e.g., subject:CN=client.bugfree.dk,O=Bugfree Consulting,C=DK
+//	cn       the subject's common name, e.g., cn:client.bugfree.dk
+//	dns      a DNS SAN, e.g., dns:client.bugfree.dk
+//	ip       an IP address SAN, e.g., ip:10.0.0.1
+//	email    an email address SAN
+//	uri      a URI SAN
+//	spki     the base64 encoded SHA-256 of the public key, as printed by certgen
+type Rule struct {
+	Kind  string
+	Value string
+}
+
+func (r Rule) String() string {
+	return r.Kind + ":" + r.Value
+}
+
+func (r Rule) matches(cert *x509.Certificate) bool {
+	switch r.Kind {
+	case "subject":
+		return cert.Subject.String() == r.Value
+	case "cn":
+		return cert.Subject.CommonName == r.Value
+	case "dns":
+		for _, n := range cert.DNSNames {
+			if strings.EqualFold(n, r.Value) {
+				return true
+			}
+		}
+	case "ip":
+		ip := net.ParseIP(r.Value)
+		for _, a := range cert.IPAddresses {
+			if a.Equal(ip) {
+				return true
+			}
+		}
+	case "email":
+		for _, a := range cert.EmailAddresses {
+			if strings.EqualFold(a, r.Value) {
+				return true
+			}
+		}
+	case "uri":
+		for _, u := range cert.URIs {
+			if u.String() == r.Value {
+				return true
+			}
+		}
+	case "spki":
+		return Pin(cert) == r.Value
+	}
+	return false
+}
+
+// Pin returns the base64 encoded SHA-256 of the certificate's public key.
+// Unlike the certificate's serial number, it stays the same when a
+// certificate is renewed with the same key.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ReadRules reads rules from a file in the format described by Rule.
+func ReadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		kind, value, ok := strings.Cut(text, ":")
+		rule := Rule{Kind: strings.ToLower(strings.TrimSpace(kind)), Value: strings.TrimSpace(value)}
+		if !ok || rule.Value == "" {
+			return nil, fmt.Errorf("%s:%d: rule must be kind:value", path, line)
+		}
+		switch rule.Kind {
+		case "subject", "cn", "dns", "email", "uri", "spki":
+		case "ip":
+			if net.ParseIP(rule.Value) == nil {
+				return nil, fmt.Errorf("%s:%d: invalid IP address: %s", path, line, rule.Value)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule kind: %s", path, line, rule.Kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
@@ -0,0 +1,99 @@
+package echoserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type clientResponse struct {
+	Addr            string    `json:"addr"`
+	Subject         string    `json:"subject"`
+	Transport       string    `json:"transport"`
+	ConnectedAt     time.Time `json:"connectedAt"`
+	UptimeSeconds   float64   `json:"uptimeSeconds"`
+	ReadOperations  uint64    `json:"readOperations"`
+	WriteOperations uint64    `json:"writeOperations"`
+	BytesReceived   uint64    `json:"bytesReceived"`
+	BytesSent       uint64    `json:"bytesSent"`
+}
+
+type statsResponse struct {
+	Clients           int    `json:"clients"`
+	Accepted          uint64 `json:"accepted"`
+	HandshakeFailures uint64 `json:"handshakeFailures"`
+	ReadOperations    uint64 `json:"readOperations"`
+	WriteOperations   uint64 `json:"writeOperations"`
+	BytesReceived     uint64 `json:"bytesReceived"`
+	BytesSent         uint64 `json:"bytesSent"`
+	IdleTimeouts      uint64 `json:"idleTimeouts"`
+	ReadTimeouts      uint64 `json:"readTimeouts"`
+	WriteTimeouts     uint64 `json:"writeTimeouts"`
+	LimitRejections   uint64 `json:"limitRejections"`
+	LimitWaits        uint64 `json:"limitWaits"`
+	ThrottledReads    uint64 `json:"throttledReads"`
+}
+
+// AdminHandler serves the server's statistics and connected clients as
+// JSON:
+//
+//	GET /stats
+//	GET /clients
+//	DELETE /clients/{addr}
+//
+// It has no authentication, so only expose it on a trusted interface.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		st := s.Stats()
+		writeJSON(w, statsResponse{
+			Clients:           st.Clients,
+			Accepted:          st.Accepted,
+			HandshakeFailures: st.HandshakeFailures,
+			ReadOperations:    st.ReadOperations,
+			WriteOperations:   st.WriteOperations,
+			BytesReceived:     st.BytesReceived,
+			BytesSent:         st.BytesSent,
+			IdleTimeouts:      st.IdleTimeouts,
+			ReadTimeouts:      st.ReadTimeouts,
+			WriteTimeouts:     st.WriteTimeouts,
+			LimitRejections:   st.LimitRejections,
+			LimitWaits:        st.LimitWaits,
+			ThrottledReads:    st.ThrottledReads,
+		})
+	})
+	mux.HandleFunc("GET /clients", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		clients := []clientResponse{}
+		for _, c := range s.Clients() {
+			clients = append(clients, clientResponse{
+				Addr:            c.Addr,
+				Subject:         c.Subject,
+				Transport:       c.Transport,
+				ConnectedAt:     c.ConnectedAt,
+				UptimeSeconds:   now.Sub(c.ConnectedAt).Seconds(),
+				ReadOperations:  c.ReadOperations,
+				WriteOperations: c.WriteOperations,
+				BytesReceived:   c.BytesReceived,
+				BytesSent:       c.BytesSent,
+			})
+		}
+		writeJSON(w, clients)
+	})
+	mux.HandleFunc("DELETE /clients/{addr}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Disconnect(r.PathValue("addr")) {
+			http.Error(w, "client not connected", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// Client may have gone away so we ignore any error.
+	enc.Encode(v)
+}
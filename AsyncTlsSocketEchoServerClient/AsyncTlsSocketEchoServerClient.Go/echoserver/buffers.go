@@ -0,0 +1,33 @@
+package echoserver
+
+import "sync"
+
+// BufferPool shares fixed-size buffers between connections. Buffers are
+// returned after each message, so connections coming and going reuse them
+// rather than allocating new ones, and garbage collection has less to do.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{size: size}
+	// Pointers to slices keep Put from allocating.
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *BufferPool) Size() int {
+	return p.size
+}
+
+func (p *BufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *BufferPool) Put(buf *[]byte) {
+	p.pool.Put(buf)
+}
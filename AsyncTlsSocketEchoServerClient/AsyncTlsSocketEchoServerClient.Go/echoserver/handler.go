@@ -0,0 +1,167 @@
+package echoserver
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/framing"
+)
+
+// Handler serves a single client connection and returns when the client
+// disconnects or an error occurs. Reads and writes on con reset the
+// connection's idle timeout and are counted in Stats.
+type Handler interface {
+	Serve(con io.ReadWriter) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(con io.ReadWriter) error
+
+func (f HandlerFunc) Serve(con io.ReadWriter) error {
+	return f(con)
+}
+
+// Echo writes back whatever it reads, using a 1024 byte buffer per
+// connection.
+func Echo() Handler {
+	return EchoBuffer(1024)
+}
+
+// EchoBuffer writes back whatever it reads, using a buffer of size bytes
+// allocated per connection. Data read along with an error, such as io.EOF,
+// is written back before the error ends the connection.
+func EchoBuffer(size int) Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		buf := make([]byte, size)
+		for {
+			n, err := con.Read(buf)
+			if n > 0 {
+				if _, err := con.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// EchoPooled writes back whatever it reads, using a buffer from pool for
+// each read and write. While blocked in Read, waiting for the client, the
+// connection still holds a buffer, as Go has no way to wait for data without
+// reading it. Like EchoBuffer, it writes back data read along with an error.
+func EchoPooled(pool *BufferPool) Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		for {
+			buf := pool.Get()
+			n, err := con.Read(*buf)
+			if n > 0 {
+				if _, writeErr := con.Write((*buf)[:n]); writeErr != nil {
+					err = writeErr
+				}
+			}
+			pool.Put(buf)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// EchoCopy writes back whatever it reads using io.Copy. Connections are
+// wrapped to count bytes and apply timeouts, which hides the TCP
+// connection's ReadFrom, so io.Copy allocates a 32 KB buffer per connection
+// rather than splicing in the kernel.
+func EchoCopy() Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		_, err := io.Copy(con, con)
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	})
+}
+
+// Discard reads and throws away whatever the client sends (RFC 863).
+func Discard() Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		_, err := io.Copy(io.Discard, con)
+		return err
+	})
+}
+
+// Chargen writes lines of rotating printable ASCII characters until the
+// client disconnects, throwing away anything it sends (RFC 864). Reading
+// what's sent lets the read timeout apply.
+func Chargen() Handler {
+	const lineLength = 72
+	var chars []byte
+	for c := byte(' '); c <= '~'; c++ {
+		chars = append(chars, c)
+	}
+
+	return HandlerFunc(func(con io.ReadWriter) error {
+		// The goroutine returns once the server closes the connection.
+		readErr := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(io.Discard, con)
+			if err == nil {
+				err = io.EOF
+			}
+			readErr <- err
+		}()
+
+		line := make([]byte, lineLength+2)
+		for first := 0; ; first = (first + 1) % len(chars) {
+			select {
+			case err := <-readErr:
+				return err
+			default:
+			}
+			for i := 0; i < lineLength; i++ {
+				line[i] = chars[(first+i)%len(chars)]
+			}
+			line[lineLength], line[lineLength+1] = '\r', '\n'
+			if _, err := con.Write(line); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Lines reads newline terminated requests and replies to each with the
+// result of respond followed by a newline. Lines longer than maxLength bytes
+// end the connection.
+func Lines(maxLength int, respond func(line string) string) Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		scanner := bufio.NewScanner(con)
+		scanner.Buffer(make([]byte, 0, 1024), maxLength)
+		for scanner.Scan() {
+			if _, err := io.WriteString(con, respond(scanner.Text())+"\n"); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	})
+}
+
+// Frames reads length-prefixed messages as defined by the framing package
+// and replies to each with the result of respond, framed the same way.
+// Messages longer than maxSize bytes end the connection.
+func Frames(maxSize int, respond func(payload []byte) []byte) Handler {
+	return HandlerFunc(func(con io.ReadWriter) error {
+		for {
+			payload, err := framing.Read(con, maxSize)
+			if err != nil {
+				return err
+			}
+			if err := framing.Write(con, respond(payload), maxSize); err != nil {
+				return err
+			}
+		}
+	})
+}
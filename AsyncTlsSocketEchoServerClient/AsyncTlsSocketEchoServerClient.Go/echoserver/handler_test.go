@@ -0,0 +1,98 @@
+package echoserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readWriter is a connection reading from r and writing to w.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func echoHandlers() map[string]Handler {
+	return map[string]Handler{
+		"buffer":       EchoBuffer(1024),
+		"small buffer": EchoBuffer(3),
+		"pooled":       EchoPooled(NewBufferPool(1024)),
+		"copy":         EchoCopy(),
+	}
+}
+
+func TestEcho(t *testing.T) {
+	const message = "hello, world"
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole reads":     func(r io.Reader) io.Reader { return r },
+		"one byte reads":  iotest.OneByteReader,
+		"data with error": iotest.DataErrReader,
+	}
+	for name, h := range echoHandlers() {
+		for readerName, wrap := range readers {
+			t.Run(name+"/"+readerName, func(t *testing.T) {
+				var echo bytes.Buffer
+				err := h.Serve(readWriter{wrap(strings.NewReader(message)), &echo})
+				if err != io.EOF {
+					t.Fatalf("got error %v, want %v", err, io.EOF)
+				}
+				if echo.String() != message {
+					t.Fatalf("echoed %q, want %q", echo.String(), message)
+				}
+			})
+		}
+	}
+}
+
+// benchConn is a client which sends messages of size bytes and then
+// disconnects. What's written back is thrown away.
+type benchConn struct {
+	messages int
+	size     int
+}
+
+func (c *benchConn) Read(b []byte) (int, error) {
+	if c.messages == 0 {
+		return 0, io.EOF
+	}
+	c.messages--
+	return min(len(b), c.size), nil
+}
+
+func (c *benchConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// benchmarkEcho serves a connection of messages per iteration, so
+// allocations are per connection.
+func benchmarkEcho(b *testing.B, h Handler) {
+	const messages = 16
+	for _, size := range []int{64, 1024} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(messages * int64(size))
+			con := &benchConn{}
+			for i := 0; i < b.N; i++ {
+				*con = benchConn{messages: messages, size: size}
+				if err := h.Serve(con); err != io.EOF {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEchoBuffer(b *testing.B) {
+	benchmarkEcho(b, EchoBuffer(1024))
+}
+
+func BenchmarkEchoPooled(b *testing.B) {
+	benchmarkEcho(b, EchoPooled(NewBufferPool(1024)))
+}
+
+func BenchmarkEchoCopy(b *testing.B) {
+	benchmarkEcho(b, EchoCopy())
+}
@@ -0,0 +1,279 @@
+package echoserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitPolicy decides what happens to a connection over a limit.
+type LimitPolicy int
+
+const (
+	// Reject closes the connection right away.
+	Reject LimitPolicy = iota
+	// Queue keeps the connection open until it's within limits or the
+	// queue timeout expires.
+	Queue
+	// Delay stops accepting connections while at the maximum number of
+	// connections, leaving new ones in the operating system's backlog.
+	// Connections over their IP's rate are queued.
+	Delay
+)
+
+func ParseLimitPolicy(s string) (LimitPolicy, error) {
+	switch s {
+	case "reject":
+		return Reject, nil
+	case "queue":
+		return Queue, nil
+	case "delay":
+		return Delay, nil
+	}
+	return 0, fmt.Errorf("unknown limit policy: %s", s)
+}
+
+// Limits protect the server from too many clients or clients sending too
+// much. Zero values mean no limit.
+type Limits struct {
+	// MaxConnections is the maximum number of concurrent connections.
+	MaxConnections int
+	// ConnectionRate is new connections per second per source IP, with
+	// bursts of up to ConnectionBurst connections.
+	ConnectionRate  float64
+	ConnectionBurst int
+	// ByteRate is bytes read per second per source IP. Reads are delayed,
+	// pushing back on clients, rather than connections closed.
+	ByteRate float64
+	Policy   LimitPolicy
+	// QueueTimeout is how long a queued connection waits before it's
+	// rejected. Default is 10 seconds.
+	QueueTimeout time.Duration
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens. Taking
+// more tokens than available puts the bucket in debt, which is how long the
+// taker must wait.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	// Callers take the time before the lock, so another caller may have
+	// refilled with a later time.
+	if now.Before(b.last) {
+		return
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) allow(now time.Time, n float64) bool {
+	b.refill(now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+type ipLimits struct {
+	connections *tokenBucket
+	bytes       *tokenBucket
+}
+
+type limiter struct {
+	Limits
+
+	slots     chan struct{} // Nil without MaxConnections.
+	lock      sync.Mutex
+	ips       map[string]*ipLimits
+	lastSweep time.Time
+
+	rejections atomic.Uint64
+	waits      atomic.Uint64
+	throttles  atomic.Uint64
+}
+
+func newLimiter(l Limits) *limiter {
+	lim := &limiter{Limits: l, ips: make(map[string]*ipLimits), lastSweep: time.Now()}
+	if l.MaxConnections > 0 {
+		lim.slots = make(chan struct{}, l.MaxConnections)
+	}
+	if lim.ConnectionBurst < 1 {
+		lim.ConnectionBurst = 1
+	}
+	if lim.QueueTimeout <= 0 {
+		lim.QueueTimeout = 10 * time.Second
+	}
+	return lim
+}
+
+// waitForSlot blocks until a connection is within the maximum number of
+// connections. It's used by the accept loop with the Delay policy.
+func (l *limiter) waitForSlot(stop <-chan struct{}) bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	l.waits.Add(1)
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// admit returns an error if a connection from addr must be rejected. With
+// the Delay policy, the slot has already been taken by waitForSlot.
+func (l *limiter) admit(ctx context.Context, addr net.Addr) error {
+	if l.ConnectionRate > 0 {
+		if err := l.admitRate(ctx, addr); err != nil {
+			l.rejections.Add(1)
+			return err
+		}
+	}
+	if l.slots == nil || l.Policy == Delay {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.Policy == Reject {
+		l.rejections.Add(1)
+		return fmt.Errorf("at maximum of %d connections", l.MaxConnections)
+	}
+	l.waits.Add(1)
+	timer := time.NewTimer(l.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		l.rejections.Add(1)
+		return fmt.Errorf("queued for %s at maximum of %d connections", l.QueueTimeout, l.MaxConnections)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) admitRate(ctx context.Context, addr net.Addr) error {
+	now := time.Now()
+	l.lock.Lock()
+	ip := l.ip(addr, now)
+	if l.Policy == Reject {
+		ok := ip.connections.allow(now, 1)
+		l.lock.Unlock()
+		if !ok {
+			return fmt.Errorf("over %g connections per second", l.ConnectionRate)
+		}
+		return nil
+	}
+	wait := ip.connections.reserve(now, 1)
+	l.lock.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	if wait > l.QueueTimeout {
+		l.lock.Lock()
+		// Return the token as the connection won't be using it.
+		ip.connections.tokens++
+		l.lock.Unlock()
+		return fmt.Errorf("over %g connections per second", l.ConnectionRate)
+	}
+	l.waits.Add(1)
+	return sleep(ctx, wait)
+}
+
+// release gives up the connection's slot.
+func (l *limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// throttle delays a read of n bytes from addr to keep the IP within its
+// byte rate.
+func (l *limiter) throttle(ctx context.Context, addr net.Addr, n int) error {
+	if l.ByteRate <= 0 || n == 0 {
+		return nil
+	}
+	now := time.Now()
+	l.lock.Lock()
+	wait := l.ip(addr, now).bytes.reserve(now, float64(n))
+	l.lock.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	l.throttles.Add(1)
+	return sleep(ctx, wait)
+}
+
+// ip returns the limits of addr's IP. Callers must hold the lock. Buckets
+// which have refilled are forgotten once a minute, so the map doesn't grow
+// with every IP ever seen.
+func (l *limiter) ip(addr net.Addr, now time.Time) *ipLimits {
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, v := range l.ips {
+			v.connections.refill(now)
+			v.bytes.refill(now)
+			if v.connections.tokens == v.connections.burst && v.bytes.tokens == v.bytes.burst {
+				delete(l.ips, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip, ok := l.ips[host]
+	if !ok {
+		// A second's worth of bytes may be read in a burst.
+		ip = &ipLimits{
+			connections: newTokenBucket(l.ConnectionRate, float64(l.ConnectionBurst), now),
+			bytes:       newTokenBucket(l.ByteRate, l.ByteRate, now),
+		}
+		l.ips[host] = ip
+	}
+	return ip
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
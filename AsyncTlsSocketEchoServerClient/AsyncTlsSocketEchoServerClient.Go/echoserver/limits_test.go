@@ -0,0 +1,247 @@
+package echoserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseLimitPolicy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want LimitPolicy
+		ok   bool
+	}{
+		{"reject", Reject, true},
+		{"queue", Queue, true},
+		{"delay", Delay, true},
+		{"", 0, false},
+		{"Reject", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimitPolicy(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLimitPolicy(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	tests := []struct {
+		name string
+		run  func(b *tokenBucket) bool
+	}{
+		{"starts full", func(b *tokenBucket) bool {
+			return b.allow(start, 1) && b.allow(start, 1) && !b.allow(start, 1)
+		}},
+		{"burst at once", func(b *tokenBucket) bool {
+			return b.allow(start, 2) && !b.allow(start, 1)
+		}},
+		{"more than burst", func(b *tokenBucket) bool {
+			return !b.allow(start, 3) && b.tokens == 2
+		}},
+		{"refills at rate", func(b *tokenBucket) bool {
+			return b.allow(start, 2) && !b.allow(at(50*time.Millisecond), 1) && b.allow(at(100*time.Millisecond), 1)
+		}},
+		{"refills up to burst", func(b *tokenBucket) bool {
+			b.allow(start, 2)
+			b.refill(at(time.Hour))
+			return b.tokens == 2
+		}},
+		{"ignores earlier time", func(b *tokenBucket) bool {
+			b.allow(at(time.Second), 2)
+			b.refill(start)
+			return b.tokens == 0 && b.last.Equal(at(time.Second))
+		}},
+		{"reserve within tokens", func(b *tokenBucket) bool {
+			return b.reserve(start, 2) == 0
+		}},
+		{"reserve into debt", func(b *tokenBucket) bool {
+			return b.reserve(start, 3) == 100*time.Millisecond && b.reserve(start, 1) == 200*time.Millisecond
+		}},
+		{"debt paid off", func(b *tokenBucket) bool {
+			b.reserve(start, 3)
+			return b.reserve(at(300*time.Millisecond), 1) == 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 10 tokens per second, so one every 100ms, and bursts of two.
+			b := newTokenBucket(10, 2, start)
+			if !tt.run(b) {
+				t.Fatalf("got %g tokens", b.tokens)
+			}
+		})
+	}
+}
+
+var testAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+
+func TestLimiterMaxConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  LimitPolicy
+		release bool // Whether the first connection goes away while the second waits.
+		admit   bool
+		waits   uint64
+	}{
+		{"reject", Reject, true, false, 0},
+		{"queue until released", Queue, true, true, 1},
+		{"queue until timeout", Queue, false, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(Limits{MaxConnections: 1, Policy: tt.policy, QueueTimeout: 100 * time.Millisecond})
+			if err := l.admit(context.Background(), testAddr); err != nil {
+				t.Fatal(err)
+			}
+			if tt.release {
+				time.AfterFunc(10*time.Millisecond, l.release)
+			}
+			err := l.admit(context.Background(), testAddr)
+			if (err == nil) != tt.admit {
+				t.Fatalf("got %v, want admitted %v", err, tt.admit)
+			}
+			if got := l.waits.Load(); got != tt.waits {
+				t.Errorf("got %d waits, want %d", got, tt.waits)
+			}
+			want := uint64(0)
+			if !tt.admit {
+				want = 1
+			}
+			if got := l.rejections.Load(); got != want {
+				t.Errorf("got %d rejections, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLimiterQueueCancelled(t *testing.T) {
+	l := newLimiter(Limits{MaxConnections: 1, Policy: Queue})
+	if err := l.admit(context.Background(), testAddr); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := l.admit(ctx, testAddr); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLimiterDelay(t *testing.T) {
+	l := newLimiter(Limits{MaxConnections: 1, Policy: Delay})
+	stop := make(chan struct{})
+	if !l.waitForSlot(stop) {
+		t.Fatal("no slot while below maximum")
+	}
+	// The accept loop has taken the slot, so admitting doesn't.
+	if err := l.admit(context.Background(), testAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, l.release)
+	if !l.waitForSlot(stop) {
+		t.Fatal("no slot after release")
+	}
+	time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+	if l.waitForSlot(stop) {
+		t.Fatal("got slot at maximum")
+	}
+	if got := l.waits.Load(); got != 2 {
+		t.Fatalf("got %d waits, want 2", got)
+	}
+}
+
+func TestLimiterConnectionRate(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       LimitPolicy
+		queueTimeout time.Duration
+		admitted     int // Of three connections at once.
+	}{
+		{"reject over burst", Reject, time.Second, 2},
+		{"queue over burst", Queue, time.Second, 3},
+		{"queue longer than timeout", Queue, 10 * time.Millisecond, 2},
+		{"delay over burst", Delay, time.Second, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The third connection has to wait 50ms.
+			l := newLimiter(Limits{ConnectionRate: 20, ConnectionBurst: 2, Policy: tt.policy, QueueTimeout: tt.queueTimeout})
+			admitted := 0
+			for i := 0; i < 3; i++ {
+				if l.admit(context.Background(), testAddr) == nil {
+					admitted++
+				}
+			}
+			if admitted != tt.admitted {
+				t.Fatalf("admitted %d, want %d", admitted, tt.admitted)
+			}
+		})
+	}
+
+	t.Run("per IP", func(t *testing.T) {
+		l := newLimiter(Limits{ConnectionRate: 1, Policy: Reject})
+		other := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1234}
+		if l.admit(context.Background(), testAddr) != nil || l.admit(context.Background(), other) != nil {
+			t.Fatal("first connection of an IP rejected")
+		}
+		if l.admit(context.Background(), testAddr) == nil {
+			t.Fatal("second connection of an IP admitted")
+		}
+	})
+
+	t.Run("unused token returned", func(t *testing.T) {
+		l := newLimiter(Limits{ConnectionRate: 1, Policy: Queue, QueueTimeout: time.Millisecond})
+		l.admit(context.Background(), testAddr)
+		l.admit(context.Background(), testAddr)
+		if tokens := l.ips["127.0.0.1"].connections.tokens; tokens < -0.01 || tokens > 0.01 {
+			t.Fatalf("got %g tokens, want none after rejection", tokens)
+		}
+	})
+}
+
+func TestLimiterThrottle(t *testing.T) {
+	l := newLimiter(Limits{ByteRate: 1000})
+	start := time.Now()
+	// A second's worth of bytes passes at once.
+	if err := l.throttle(context.Background(), testAddr, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if l.throttles.Load() != 0 {
+		t.Fatal("throttled within burst")
+	}
+	if err := l.throttle(context.Background(), testAddr, 50); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || l.throttles.Load() != 1 {
+		t.Fatalf("took %s and %d throttles, want about 50ms and 1", elapsed, l.throttles.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.throttle(ctx, testAddr, 1000); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if err := (newLimiter(Limits{})).throttle(ctx, testAddr, 1<<30); err != nil {
+		t.Fatalf("got %v without a byte rate", err)
+	}
+}
+
+func TestLimiterForgetsIdleIPs(t *testing.T) {
+	l := newLimiter(Limits{ConnectionRate: 10, Policy: Reject})
+	now := time.Now()
+	l.ip(testAddr, now).connections.allow(now, 1)
+	if len(l.ips) != 1 {
+		t.Fatalf("got %d IPs, want 1", len(l.ips))
+	}
+	other := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1234}
+	l.ip(other, now.Add(2*time.Minute))
+	if _, ok := l.ips["127.0.0.1"]; ok || len(l.ips) != 1 {
+		t.Fatalf("got %d IPs, want only the new one", len(l.ips))
+	}
+}
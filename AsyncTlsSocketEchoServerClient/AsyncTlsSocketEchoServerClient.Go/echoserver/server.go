@@ -0,0 +1,549 @@
+// Package echoserver implements a TCP or TLS socket server which by default
+// echoes what clients send. Other protocols are served by passing a different
+// Handler. Any number of servers may be created, e.g., in tests or other
+// tools.
+package echoserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are totals across current and past connections.
+type Stats struct {
+	Clients           int
+	Accepted          uint64
+	HandshakeFailures uint64
+	ReadOperations    uint64
+	WriteOperations   uint64
+	BytesReceived     uint64
+	BytesSent         uint64
+	IdleTimeouts      uint64
+	ReadTimeouts      uint64
+	WriteTimeouts     uint64
+
+	// Connections rejected or made to wait by Limits, and reads delayed by
+	// the byte rate limit. With the Delay policy, waits count the times the
+	// server stopped accepting connections.
+	LimitRejections uint64
+	LimitWaits      uint64
+	ThrottledReads  uint64
+}
+
+type statistics struct {
+	readOperations  atomic.Uint64
+	writeOperations atomic.Uint64
+	bytesReceived   atomic.Uint64
+	bytesSent       atomic.Uint64
+}
+
+func (st *statistics) add(other *statistics) {
+	st.readOperations.Add(other.readOperations.Load())
+	st.writeOperations.Add(other.writeOperations.Load())
+	st.bytesReceived.Add(other.bytesReceived.Load())
+	st.bytesSent.Add(other.bytesSent.Load())
+}
+
+// ClientInfo describes a connected client.
+type ClientInfo struct {
+	Addr            string
+	Subject         string
+	Transport       string // tcp, tls, ws, or wss.
+	ConnectedAt     time.Time
+	ReadOperations  uint64
+	WriteOperations uint64
+	BytesReceived   uint64
+	BytesSent       uint64
+}
+
+// OptFunc configures a Server in the style of GoOptionsPattern.
+type OptFunc func(*opts)
+
+type opts struct {
+	addr             string
+	tlsConfig        *tls.Config
+	logger           *log.Logger
+	idleTimeout      time.Duration
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	keepAlive        time.Duration
+	handshakeTimeout time.Duration
+	handler          Handler
+	limits           Limits
+}
+
+func defaultOpts() opts {
+	return opts{
+		addr:             "127.0.0.1:8080",
+		logger:           log.Default(),
+		idleTimeout:      240 * time.Second,
+		handshakeTimeout: 10 * time.Second,
+		handler:          Echo(),
+	}
+}
+
+// WithAddr sets the endpoint to listen on. Use port 0 for an ephemeral port
+// and call Addr after Start to find it.
+func WithAddr(addr string) OptFunc {
+	return func(o *opts) {
+		o.addr = addr
+	}
+}
+
+// WithTLSConfig makes the server speak TLS. Default is nil, which means
+// plain TCP.
+func WithTLSConfig(config *tls.Config) OptFunc {
+	return func(o *opts) {
+		o.tlsConfig = config
+	}
+}
+
+// WithLogger directs log output to logger. Pass a logger writing to
+// io.Discard to silence the server.
+func WithLogger(logger *log.Logger) OptFunc {
+	return func(o *opts) {
+		o.logger = logger
+	}
+}
+
+// WithIdleTimeout sets how long a client may go without reading or writing
+// before it's disconnected. Default is 240 seconds and zero means forever.
+func WithIdleTimeout(d time.Duration) OptFunc {
+	return func(o *opts) {
+		o.idleTimeout = d
+	}
+}
+
+// WithReadTimeout sets how long a client may go without sending anything,
+// even while the server is sending to it, before it's disconnected. Default
+// is zero, which means only the idle timeout applies.
+func WithReadTimeout(d time.Duration) OptFunc {
+	return func(o *opts) {
+		o.readTimeout = d
+	}
+}
+
+// WithWriteTimeout sets how long a single write may take, e.g., because the
+// client doesn't read, before the client is disconnected. Default is zero,
+// which means only the idle timeout applies.
+func WithWriteTimeout(d time.Duration) OptFunc {
+	return func(o *opts) {
+		o.writeTimeout = d
+	}
+}
+
+// WithKeepAlive sets the interval of TCP keepalive probes, which detect
+// clients that went away without closing the connection. Default is zero,
+// which means the operating system's default, and negative disables them.
+func WithKeepAlive(d time.Duration) OptFunc {
+	return func(o *opts) {
+		o.keepAlive = d
+	}
+}
+
+// WithHandshakeTimeout sets how long a client has to complete the TLS
+// handshake after connecting.
+func WithHandshakeTimeout(d time.Duration) OptFunc {
+	return func(o *opts) {
+		o.handshakeTimeout = d
+	}
+}
+
+// WithLimits limits connections and how fast clients may send. Default is
+// no limits.
+func WithLimits(l Limits) OptFunc {
+	return func(o *opts) {
+		o.limits = l
+	}
+}
+
+// WithHandler sets what the server does with connections. Default is Echo.
+func WithHandler(h Handler) OptFunc {
+	return func(o *opts) {
+		o.handler = h
+	}
+}
+
+type Server struct {
+	opts
+
+	listener    net.Listener
+	clients     map[string]*conn
+	clientsLock sync.Mutex
+	accepted    atomic.Uint64
+	failures    atomic.Uint64 // Handshakes.
+	timeouts    [timeoutKinds]atomic.Uint64
+	stats       statistics // Of closed connections.
+	wg          sync.WaitGroup
+	acceptDone  chan struct{}
+	stopAccept  chan struct{}
+	limiter     *limiter
+
+	// Canceled when Stop gives up on draining, which aborts handshakes in
+	// progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New creates a server. It doesn't listen until Start is called.
+func New(options ...OptFunc) *Server {
+	o := defaultOpts()
+	for _, fn := range options {
+		fn(&o)
+	}
+
+	return &Server{
+		opts:    o,
+		clients: make(map[string]*conn),
+		limiter: newLimiter(o.limits),
+	}
+}
+
+// Start listens on the configured endpoint and accepts connections in the
+// background. It returns once the server is listening.
+func (s *Server) Start() error {
+	lc := net.ListenConfig{KeepAlive: s.keepAlive}
+	listener, err := lc.Listen(context.Background(), "tcp4", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	s.listener = listener
+	s.acceptDone = make(chan struct{})
+	// Under the lock, as WebSocket handlers may already be serving.
+	s.clientsLock.Lock()
+	s.stopAccept = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.clientsLock.Unlock()
+	s.logger.Printf("Listening on %s", listener.Addr())
+
+	go s.acceptLoop()
+	return nil
+}
+
+// Addr is the endpoint the server listens on, or nil before Start.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) acceptLoop() {
+	defer close(s.acceptDone)
+	delay := s.limits.Policy == Delay
+	for {
+		if delay && !s.limiter.waitForSlot(s.stopAccept) {
+			return
+		}
+		con, err := s.listener.Accept()
+		if err != nil {
+			if delay {
+				s.limiter.release()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Printf("Unable to accept connection: %s", err)
+			continue
+		}
+
+		// Handshaking in the accept loop would let a slow or malicious
+		// client hold up every other client.
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			tlsCon, ok := con.(*tls.Conn)
+			if !ok {
+				s.serve(con, "tcp", func() bool { return true })
+				return
+			}
+			s.serve(con, "tls", func() bool { return s.handshake(tlsCon) })
+		}()
+	}
+}
+
+// serve admits con within limits, lets handshake complete any handshake,
+// and serves con with the handler. With the Delay policy, the caller has
+// already taken a slot.
+func (s *Server) serve(con net.Conn, transport string, handshake func() bool) {
+	if err := s.limiter.admit(s.ctx, con.RemoteAddr()); err != nil {
+		if s.limits.Policy == Delay {
+			s.limiter.release()
+		}
+		s.logger.Printf("Rejected connection from %s: %s", con.RemoteAddr(), err)
+		// It may already have been closed so we ignore any error.
+		con.Close()
+		return
+	}
+	defer s.limiter.release()
+
+	if !handshake() {
+		return
+	}
+	s.handle(con, transport)
+}
+
+// handshake completes the TLS handshake within the handshake timeout. On
+// failure, it closes the connection and returns false.
+func (s *Server) handshake(con *tls.Conn) bool {
+	ctx, cancel := context.WithTimeout(s.ctx, s.handshakeTimeout)
+	defer cancel()
+	err := con.HandshakeContext(ctx)
+	if err != nil {
+		s.failures.Add(1)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", s.handshakeTimeout)
+		}
+		s.logger.Printf("Handshake with %s failed: %s", con.RemoteAddr(), err)
+		// It may already have been closed so we ignore any error.
+		con.Close()
+		return false
+	}
+
+	state := con.ConnectionState()
+	for _, v := range state.PeerCertificates {
+		s.logger.Printf("PeerCertificate: %v", v.Subject)
+	}
+	s.logger.Printf("Handshake with %s complete: %s, protocol %q, resumed: %v",
+		con.RemoteAddr(), tls.VersionName(state.Version), state.NegotiatedProtocol, state.DidResume)
+	return true
+}
+
+// conn applies timeouts and limits and updates statistics on every read and
+// write, so handlers don't have to.
+type conn struct {
+	con         net.Conn
+	transport   string
+	ctx         context.Context
+	limiter     *limiter
+	timeouts    timeouts
+	subject     string
+	connectedAt time.Time
+	stats       statistics
+	closed      atomic.Bool // By the server rather than the client.
+
+	// Unix nanoseconds, as a handler may read and write concurrently.
+	lastRead     atomic.Int64
+	lastActivity atomic.Int64
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	deadline, timeout := c.timeouts.readDeadline(time.Unix(0, c.lastRead.Load()), time.Unix(0, c.lastActivity.Load()))
+	if err := c.con.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := c.con.Read(b)
+	if n != 0 {
+		now := time.Now().UnixNano()
+		c.lastRead.Store(now)
+		c.lastActivity.Store(now)
+		c.stats.readOperations.Add(1)
+		c.stats.bytesReceived.Add(uint64(n))
+		if err == nil {
+			err = c.limiter.throttle(c.ctx, c.con.RemoteAddr(), n)
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = timeout
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	deadline, timeout := c.timeouts.writeDeadline(time.Now())
+	if err := c.con.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := c.con.Write(b)
+	if n != 0 {
+		c.lastActivity.Store(time.Now().UnixNano())
+		c.stats.writeOperations.Add(1)
+		c.stats.bytesSent.Add(uint64(n))
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = timeout
+	}
+	return n, err
+}
+
+// close disconnects the client. Errors from the handler are then expected
+// and not logged.
+func (c *conn) close() {
+	c.closed.Store(true)
+	// Client may be disconnecting on its own so we ignore any error.
+	c.con.Close()
+}
+
+func (c *conn) info() ClientInfo {
+	return ClientInfo{
+		Addr:            c.con.RemoteAddr().String(),
+		Subject:         c.subject,
+		Transport:       c.transport,
+		ConnectedAt:     c.connectedAt,
+		ReadOperations:  c.stats.readOperations.Load(),
+		WriteOperations: c.stats.writeOperations.Load(),
+		BytesReceived:   c.stats.bytesReceived.Load(),
+		BytesSent:       c.stats.bytesSent.Load(),
+	}
+}
+
+func (s *Server) handle(con net.Conn, transport string) {
+	c := &conn{
+		con:         con,
+		transport:   transport,
+		ctx:         s.ctx,
+		limiter:     s.limiter,
+		timeouts:    timeouts{idle: s.idleTimeout, read: s.readTimeout, write: s.writeTimeout},
+		connectedAt: time.Now(),
+	}
+	c.lastRead.Store(c.connectedAt.UnixNano())
+	c.lastActivity.Store(c.connectedAt.UnixNano())
+	if state, ok := connectionState(con); ok && len(state.PeerCertificates) > 0 {
+		c.subject = state.PeerCertificates[0].Subject.String()
+	}
+	addr := con.RemoteAddr().String()
+	defer func() {
+		// It may already have been closed so we ignore any error.
+		con.Close()
+
+		// Moving counters to the totals under the lock keeps Stats from
+		// counting the connection twice or not at all.
+		s.clientsLock.Lock()
+		delete(s.clients, addr)
+		s.stats.add(&c.stats)
+		s.logger.Printf("Disconnected client %s (clients: %d)", addr, len(s.clients))
+		s.clientsLock.Unlock()
+	}()
+
+	s.accepted.Add(1)
+	s.clientsLock.Lock()
+	s.clients[addr] = c
+	s.logger.Printf("Accepted client from %s (clients: %d)", addr, len(s.clients))
+	s.clientsLock.Unlock()
+
+	err := s.handler.Serve(c)
+	var timeout *TimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		s.timeouts[timeout.Kind].Add(1)
+		s.logger.Printf("Client %s timed out: %s", addr, timeout)
+	case err != nil && err != io.EOF && !c.closed.Load():
+		s.logger.Printf("Error serving client %s: %s", addr, err)
+	}
+}
+
+// Clients returns connected clients ordered by address.
+func (s *Server) Clients() []ClientInfo {
+	s.clientsLock.Lock()
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c.info())
+	}
+	s.clientsLock.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Addr < clients[j].Addr })
+	return clients
+}
+
+// Disconnect closes the connection to the client with the given address. It
+// returns false if no such client is connected.
+func (s *Server) Disconnect(addr string) bool {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	c, ok := s.clients[addr]
+	if !ok {
+		return false
+	}
+	s.logger.Printf("Disconnecting client %s", addr)
+	c.close()
+	return true
+}
+
+// Stop stops accepting new connections and lets connected clients finish
+// until ctx is done. Clients still connected at that point are disconnected
+// and Stop returns ctx.Err() once their handlers have returned.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.listener == nil {
+		return nil
+	}
+	// Stop may be called more than once. Closing under the lock keeps
+	// WebSocket clients from being added once draining has begun.
+	s.clientsLock.Lock()
+	select {
+	case <-s.stopAccept:
+	default:
+		close(s.stopAccept)
+	}
+	s.clientsLock.Unlock()
+	// Listener may have already been closed so we ignore any error.
+	s.listener.Close()
+	<-s.acceptDone
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	s.clientsLock.Lock()
+	s.logger.Printf("Draining %d clients", len(s.clients))
+	s.clientsLock.Unlock()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.cancel()
+	s.clientsLock.Lock()
+	s.logger.Printf("Disconnecting %d clients", len(s.clients))
+	for _, c := range s.clients {
+		c.close()
+	}
+	s.clientsLock.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// Stats may be called while the server is running, in which case counters
+// include reads and writes on current connections.
+func (s *Server) Stats() Stats {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	var st statistics
+	st.add(&s.stats)
+	for _, c := range s.clients {
+		st.add(&c.stats)
+	}
+	return Stats{
+		Clients:           len(s.clients),
+		Accepted:          s.accepted.Load(),
+		HandshakeFailures: s.failures.Load(),
+		IdleTimeouts:      s.timeouts[IdleTimeout].Load(),
+		ReadTimeouts:      s.timeouts[ReadTimeout].Load(),
+		WriteTimeouts:     s.timeouts[WriteTimeout].Load(),
+		LimitRejections:   s.limiter.rejections.Load(),
+		LimitWaits:        s.limiter.waits.Load(),
+		ThrottledReads:    s.limiter.throttles.Load(),
+		ReadOperations:    st.readOperations.Load(),
+		WriteOperations:   st.writeOperations.Load(),
+		BytesReceived:     st.bytesReceived.Load(),
+		BytesSent:         st.bytesSent.Load(),
+	}
+}
@@ -0,0 +1,82 @@
+package echoserver
+
+import (
+	"fmt"
+	"time"
+)
+
+type TimeoutKind int
+
+const (
+	IdleTimeout TimeoutKind = iota
+	ReadTimeout
+	WriteTimeout
+	timeoutKinds
+)
+
+func (k TimeoutKind) String() string {
+	switch k {
+	case IdleTimeout:
+		return "idle"
+	case ReadTimeout:
+		return "read"
+	case WriteTimeout:
+		return "write"
+	}
+	return "unknown"
+}
+
+// TimeoutError is returned to handlers by reads and writes which time out,
+// so timeouts aren't reported as other errors.
+type TimeoutError struct {
+	Kind  TimeoutKind
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %s", e.Kind, e.After)
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// timeouts computes deadlines for a connection. Zero durations mean no
+// timeout.
+type timeouts struct {
+	idle  time.Duration
+	read  time.Duration
+	write time.Duration
+}
+
+// readDeadline returns the deadline for a read, which is whichever of the read and
+// idle timeouts expires first, and the error to report if it's exceeded.
+func (t timeouts) readDeadline(lastRead, lastActivity time.Time) (time.Time, *TimeoutError) {
+	var deadline time.Time
+	var err *TimeoutError
+	if t.read > 0 {
+		deadline, err = lastRead.Add(t.read), &TimeoutError{ReadTimeout, t.read}
+	}
+	if t.idle > 0 {
+		if d := lastActivity.Add(t.idle); deadline.IsZero() || d.Before(deadline) {
+			deadline, err = d, &TimeoutError{IdleTimeout, t.idle}
+		}
+	}
+	return deadline, err
+}
+
+// writeDeadline returns the deadline for a write starting now. A write in progress
+// counts as activity, so the idle timeout runs from now too.
+func (t timeouts) writeDeadline(now time.Time) (time.Time, *TimeoutError) {
+	var deadline time.Time
+	var err *TimeoutError
+	if t.write > 0 {
+		deadline, err = now.Add(t.write), &TimeoutError{WriteTimeout, t.write}
+	}
+	if t.idle > 0 {
+		if d := now.Add(t.idle); deadline.IsZero() || d.Before(deadline) {
+			deadline, err = d, &TimeoutError{IdleTimeout, t.idle}
+		}
+	}
+	return deadline, err
+}
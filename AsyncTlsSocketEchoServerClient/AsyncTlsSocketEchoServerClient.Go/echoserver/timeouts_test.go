@@ -0,0 +1,90 @@
+package echoserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		timeouts     timeouts
+		lastRead     time.Time
+		lastActivity time.Time
+		want         time.Time
+		kind         TimeoutKind
+	}{
+		{"none", timeouts{}, now, now, time.Time{}, timeoutKinds},
+		{"write only", timeouts{write: time.Second}, now, now, time.Time{}, timeoutKinds},
+		{"read only", timeouts{read: time.Second}, now, now, now.Add(time.Second), ReadTimeout},
+		{"idle only", timeouts{idle: time.Second}, now, now, now.Add(time.Second), IdleTimeout},
+		{"read first", timeouts{idle: 2 * time.Second, read: time.Second}, now, now, now.Add(time.Second), ReadTimeout},
+		{"idle first", timeouts{idle: time.Second, read: 2 * time.Second}, now, now, now.Add(time.Second), IdleTimeout},
+		{"same time", timeouts{idle: time.Second, read: time.Second}, now, now, now.Add(time.Second), ReadTimeout},
+		// Writing since the last read keeps the connection from being idle, but
+		// not a read from timing out.
+		{"recent write", timeouts{idle: time.Second, read: 2 * time.Second}, now.Add(-1500 * time.Millisecond), now, now.Add(500 * time.Millisecond), ReadTimeout},
+		{"idle since read", timeouts{idle: time.Second, read: 2 * time.Second}, now.Add(-1500 * time.Millisecond), now.Add(-1500 * time.Millisecond), now.Add(-500 * time.Millisecond), IdleTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.timeouts.readDeadline(tt.lastRead, tt.lastActivity)
+			checkDeadline(t, got, err, tt.want, tt.kind)
+		})
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		timeouts timeouts
+		want     time.Time
+		kind     TimeoutKind
+	}{
+		{"none", timeouts{}, time.Time{}, timeoutKinds},
+		{"read only", timeouts{read: time.Second}, time.Time{}, timeoutKinds},
+		{"write only", timeouts{write: time.Second}, now.Add(time.Second), WriteTimeout},
+		{"idle only", timeouts{idle: time.Second}, now.Add(time.Second), IdleTimeout},
+		{"write first", timeouts{idle: 2 * time.Second, write: time.Second}, now.Add(time.Second), WriteTimeout},
+		{"idle first", timeouts{idle: time.Second, write: 2 * time.Second}, now.Add(time.Second), IdleTimeout},
+		{"same time", timeouts{idle: time.Second, write: time.Second}, now.Add(time.Second), WriteTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.timeouts.writeDeadline(now)
+			checkDeadline(t, got, err, tt.want, tt.kind)
+		})
+	}
+}
+
+// checkDeadline checks a deadline and its error, where kind timeoutKinds
+// means no deadline.
+func checkDeadline(t *testing.T, got time.Time, err *TimeoutError, want time.Time, kind TimeoutKind) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("got deadline %s, want %s", got, want)
+	}
+	if kind == timeoutKinds {
+		if err != nil {
+			t.Errorf("got %v, want no timeout", err)
+		}
+		return
+	}
+	if err == nil || err.Kind != kind {
+		t.Errorf("got %v, want %s timeout", err, kind)
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	var err error = &TimeoutError{IdleTimeout, time.Second}
+	var timeout interface{ Timeout() bool }
+	if !errors.As(err, &timeout) || !timeout.Timeout() {
+		t.Fatal("not a timeout")
+	}
+	if got, want := err.Error(), "idle timeout after 1s"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package echoserver
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/websocket"
+)
+
+// WebSocketHandler bridges WebSocket clients, e.g., browsers, to the
+// server's handler. Each binary or text message a client sends is read as
+// part of a byte stream and each write is sent as a binary message. The
+// connection is then subject to the same limits and timeouts as TCP and TLS
+// clients, is counted in Stats, and is drained by Stop. Serve it over TLS,
+// using the server's TLS configuration, to require client certificates.
+// Until Start is called, it replies that the service is unavailable.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Adding to the wait group under the lock keeps Stop from waiting
+		// on it before the client is added.
+		s.clientsLock.Lock()
+		if s.stopAccept == nil {
+			s.clientsLock.Unlock()
+			http.Error(w, "server isn't running", http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case <-s.stopAccept:
+			s.clientsLock.Unlock()
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		s.wg.Add(1)
+		s.clientsLock.Unlock()
+		defer s.wg.Done()
+
+		if s.limits.Policy == Delay && !s.limiter.waitForSlot(s.stopAccept) {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		con, err := websocket.Upgrade(w, r)
+		if err != nil {
+			if s.limits.Policy == Delay {
+				s.limiter.release()
+			}
+			s.logger.Printf("WebSocket handshake with %s failed: %s", r.RemoteAddr, err)
+			return
+		}
+
+		transport := "ws"
+		if r.TLS != nil {
+			transport = "wss"
+		}
+		s.serve(con, transport, func() bool { return true })
+	})
+}
+
+// connectionState returns the TLS state of con, which may be a TLS
+// connection or a WebSocket connection over one.
+func connectionState(con net.Conn) (tls.ConnectionState, bool) {
+	switch c := con.(type) {
+	case *tls.Conn:
+		return c.ConnectionState(), true
+	case *websocket.Conn:
+		if tlsCon, ok := c.Conn.(*tls.Conn); ok {
+			return tlsCon.ConnectionState(), true
+		}
+	}
+	return tls.ConnectionState{}, false
+}
@@ -0,0 +1,18 @@
+package echoserver
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSocketHandlerBeforeStart(t *testing.T) {
+	s := New(WithLogger(log.New(io.Discard, "", 0)))
+	w := httptest.NewRecorder()
+	s.WebSocketHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
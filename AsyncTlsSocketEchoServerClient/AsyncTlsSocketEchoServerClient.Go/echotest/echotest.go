@@ -0,0 +1,279 @@
+// Package echotest runs an echo server in-process on an ephemeral port with
+// generated certificates, and clients against it, for integration tests
+// which need a real server, much like net/http/httptest.
+package echotest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/clientauth"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/echoserver"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/framing"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/websocket"
+)
+
+const maxFrameSize = 64 * 1024
+
+// Server is an echo server requiring mutual TLS with certificates issued
+// by its own certificate authority.
+type Server struct {
+	*echoserver.Server
+	CA *pki.CA
+	// ClientCert is issued by CA, so the server accepts it.
+	ClientCert tls.Certificate
+	// Addr is the IP and port the server listens on.
+	Addr string
+
+	tlsConfig *tls.Config
+}
+
+// NewServer generates certificates and starts a server on 127.0.0.1 on an
+// ephemeral port, failing tb if it can't. The server is closed when tb
+// and its subtests complete, if not before. Options are applied after the
+// defaults, so they may, e.g., replace the handler. Logging is discarded
+// unless an option sets a logger.
+func NewServer(tb testing.TB, options ...echoserver.OptFunc) *Server {
+	tb.Helper()
+	return newServer(tb, nil, options)
+}
+
+// NewServerWithPolicy is NewServer with clients also verified against the
+// policy returned by policy, as by the server's -allow, -deny and -crl
+// flags. Policy is called with the certificate authority before the server
+// starts, so it may, e.g., issue certificates and revoke them.
+func NewServerWithPolicy(tb testing.TB, policy func(*pki.CA) *clientauth.Policy, options ...echoserver.OptFunc) *Server {
+	tb.Helper()
+	return newServer(tb, policy, options)
+}
+
+func newServer(tb testing.TB, policy func(*pki.CA) *clientauth.Policy, options []echoserver.OptFunc) *Server {
+	tb.Helper()
+	ca, err := pki.NewCA(pkix.Name{CommonName: "echotest CA"}, time.Hour)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	serverCert, err := issue(ca.IssueServer, "echotest server", []string{"127.0.0.1"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	clientCert, err := issue(ca.IssueClient, "echotest client", nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	if policy != nil {
+		config = policy(ca).Apply(config)
+	}
+	options = append([]echoserver.OptFunc{
+		echoserver.WithAddr("127.0.0.1:0"),
+		echoserver.WithTLSConfig(config),
+		echoserver.WithLogger(log.New(io.Discard, "", 0)),
+	}, options...)
+
+	s := &Server{Server: echoserver.New(options...), CA: ca, ClientCert: clientCert, tlsConfig: config}
+	if err := s.Start(); err != nil {
+		tb.Fatal(err)
+	}
+	// Stop may be called more than once, so tests may close the server
+	// themselves.
+	tb.Cleanup(func() { s.Close(time.Second) })
+	s.Addr = s.Server.Addr().String()
+	return s
+}
+
+func issue(issuer func(pkix.Name, []string, time.Duration) (*pki.Certificate, error), name string, hosts []string) (tls.Certificate, error) {
+	c, err := issuer(pkix.Name{CommonName: name}, hosts, time.Hour)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return c.TLSCertificate()
+}
+
+// ClientConfig trusts the server and presents ClientCert.
+func (s *Server) ClientConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{s.ClientCert},
+		RootCAs:      s.CA.Pool(),
+		ServerName:   "127.0.0.1",
+	}
+}
+
+// Dial connects to the server and completes the handshake. With TLS 1.3,
+// the server's verdict on the client's certificate only arrives with the
+// first read.
+func (s *Server) Dial(config *tls.Config) (*tls.Conn, error) {
+	return tls.Dial("tcp4", s.Addr, config)
+}
+
+// ServeGateway serves the server's WebSocket gateway on 127.0.0.1 on an
+// ephemeral port until tb completes, over TLS with the server's
+// configuration if secure, and returns the gateway's address.
+func (s *Server) ServeGateway(tb testing.TB, secure bool) string {
+	tb.Helper()
+	gateway := httptest.NewUnstartedServer(s.WebSocketHandler())
+	if secure {
+		gateway.TLS = s.tlsConfig.Clone()
+		gateway.StartTLS()
+	} else {
+		gateway.Start()
+	}
+	tb.Cleanup(gateway.Close)
+	return gateway.Listener.Addr().String()
+}
+
+// DialGateway connects to the gateway at addr, over TLS with config unless
+// it's nil, and completes the WebSocket handshake.
+func DialGateway(addr string, config *tls.Config) (*websocket.Conn, error) {
+	var (
+		con net.Conn
+		err error
+	)
+	if config == nil {
+		con, err = net.Dial("tcp4", addr)
+	} else {
+		con, err = tls.Dial("tcp4", addr, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.Client(con, addr, "/")
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Close stops the server, disconnecting clients still connected after
+// drain.
+func (s *Server) Close(drain time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	return s.Stop(ctx)
+}
+
+// WaitFor polls the server's statistics until cond holds or timeout
+// expires. Statistics lag behind clients, e.g., a client's connection is
+// only removed once the server has read its close.
+func (s *Server) WaitFor(timeout time.Duration, cond func(echoserver.Stats) bool) (echoserver.Stats, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		st := s.Stats()
+		if cond(st) {
+			return st, true
+		}
+		if time.Now().After(deadline) {
+			return st, false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Result is what clients run by RunClients sent and received.
+type Result struct {
+	Messages   uint64
+	Bytes      uint64 // Of payloads, excluding framing.
+	Mismatches uint64
+	Errors     []error
+}
+
+// RunClients connects n clients which each echo messages random payloads
+// of up to maxSize bytes, framed as by the framing package, and verify that
+// what comes back is what was sent. Clients disconnect when done. Errors,
+// e.g., from a server rejecting clients, are returned in the result rather
+// than failing tb.
+func RunClients(tb testing.TB, s *Server, config *tls.Config, n, messages, maxSize int) Result {
+	tb.Helper()
+	return run(tb, n, messages, maxSize, func() (net.Conn, error) { return s.Dial(config) })
+}
+
+// RunGatewayClients is RunClients with clients connecting through the
+// gateway at addr, as by DialGateway.
+func RunGatewayClients(tb testing.TB, addr string, config *tls.Config, n, messages, maxSize int) Result {
+	tb.Helper()
+	return run(tb, n, messages, maxSize, func() (net.Conn, error) { return DialGateway(addr, config) })
+}
+
+func run(tb testing.TB, n, messages, maxSize int, dial func() (net.Conn, error)) Result {
+	tb.Helper()
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		result Result
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := runClient(dial, messages, maxSize, rand.New(rand.NewSource(seed)))
+			lock.Lock()
+			defer lock.Unlock()
+			result.Messages += r.Messages
+			result.Bytes += r.Bytes
+			result.Mismatches += r.Mismatches
+			result.Errors = append(result.Errors, r.Errors...)
+		}(int64(i))
+	}
+	wg.Wait()
+	tb.Logf("%d clients: %s", n, result)
+	return result
+}
+
+func runClient(dial func() (net.Conn, error), messages, maxSize int, r *rand.Rand) Result {
+	var result Result
+	con, err := dial()
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+		return result
+	}
+	defer con.Close()
+
+	buf := make([]byte, maxSize)
+	for i := 0; i < messages; i++ {
+		payload := buf[:r.Intn(maxSize+1)]
+		r.Read(payload)
+		if err := framing.Write(con, payload, maxFrameSize); err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
+		}
+		echo, err := framing.Read(con, maxFrameSize)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
+		}
+		result.Messages++
+		result.Bytes += uint64(len(payload))
+		if !bytes.Equal(payload, echo) {
+			result.Mismatches++
+		}
+	}
+	return result
+}
+
+// WireBytes is the number of bytes the server reads and writes for result,
+// including each message's framing header.
+func (r Result) WireBytes() uint64 {
+	return r.Bytes + r.Messages*framing.HeaderSize
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%d messages, %d bytes, %d mismatches, %d errors", r.Messages, r.Bytes, r.Mismatches, len(r.Errors))
+}
@@ -0,0 +1,280 @@
+package echotest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/clientauth"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/echoserver"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/echotest"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/pki"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/websocket"
+)
+
+const (
+	clients  = 50
+	messages = 100
+	maxSize  = 4096
+	// Generous, as the race detector slows everything down.
+	waitTimeout = 10 * time.Second
+)
+
+func TestEcho(t *testing.T) {
+	s := echotest.NewServer(t)
+
+	r := echotest.RunClients(t, s, s.ClientConfig(), clients, messages, maxSize)
+	if len(r.Errors) > 0 {
+		t.Fatalf("%s, first: %s", r, r.Errors[0])
+	}
+	if want := uint64(clients * messages); r.Messages != want || r.Mismatches > 0 {
+		t.Fatalf("%s, want %d messages and no mismatches", r, want)
+	}
+
+	st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.Clients == 0 })
+	if !ok {
+		t.Fatalf("%d clients still connected", st.Clients)
+	}
+	if st.Accepted != clients {
+		t.Errorf("accepted %d clients, want %d", st.Accepted, clients)
+	}
+	if st.BytesReceived != r.WireBytes() || st.BytesSent != r.WireBytes() {
+		t.Errorf("received %d and sent %d bytes, want %d", st.BytesReceived, st.BytesSent, r.WireBytes())
+	}
+	if st.ReadOperations < r.Messages || st.WriteOperations < r.Messages {
+		t.Errorf("%d reads and %d writes for %d messages", st.ReadOperations, st.WriteOperations, r.Messages)
+	}
+}
+
+func TestUnauthenticatedClientsRejected(t *testing.T) {
+	s := echotest.NewServer(t)
+
+	// A client certificate from another certificate authority.
+	other, err := pki.NewCA(pkix.Name{CommonName: "other CA"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := other.IssueClient(pkix.Name{CommonName: "other client"}, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, err := c.TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noCert := s.ClientConfig()
+	noCert.Certificates = nil
+	untrusted := s.ClientConfig()
+	untrusted.Certificates = []tls.Certificate{otherCert}
+
+	for name, config := range map[string]*tls.Config{"no certificate": noCert, "untrusted certificate": untrusted} {
+		if r := echotest.RunClients(t, s, config, 1, 1, 0); len(r.Errors) == 0 {
+			t.Errorf("client with %s wasn't rejected", name)
+		}
+	}
+
+	st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.HandshakeFailures == 2 })
+	if !ok {
+		t.Errorf("%d failed handshakes, want 2", st.HandshakeFailures)
+	}
+	if st.Accepted != 0 {
+		t.Errorf("accepted %d clients, want 0", st.Accepted)
+	}
+}
+
+// dial connects n clients and waits for the server to have them all.
+func dial(t *testing.T, s *echotest.Server, n int) []*tls.Conn {
+	t.Helper()
+	cons := make([]*tls.Conn, n)
+	for i := range cons {
+		con, err := s.Dial(s.ClientConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { con.Close() })
+		cons[i] = con
+	}
+	if st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.Clients == n }); !ok {
+		t.Fatalf("%d clients connected, want %d", st.Clients, n)
+	}
+	return cons
+}
+
+// TestShutdownDrainsClients has clients disconnect while the server drains,
+// which it then does without disconnecting them.
+func TestShutdownDrainsClients(t *testing.T) {
+	s := echotest.NewServer(t)
+	cons := dial(t, s, clients)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Close(waitTimeout) }()
+	time.Sleep(100 * time.Millisecond)
+
+	if con, err := s.Dial(s.ClientConfig()); err == nil {
+		con.Close()
+		t.Error("server accepted a client while draining")
+	}
+	for _, con := range cons {
+		con.Close()
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+}
+
+// TestShutdownDisconnectsClientsAfterDrain has clients stay connected while
+// the server drains, after which it disconnects them.
+func TestShutdownDisconnectsClientsAfterDrain(t *testing.T) {
+	s := echotest.NewServer(t)
+	cons := dial(t, s, clients)
+
+	if err := s.Close(100 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if st := s.Stats(); st.Clients != 0 {
+		t.Fatalf("%d clients still connected", st.Clients)
+	}
+	for _, con := range cons {
+		con.SetReadDeadline(time.Now().Add(waitTimeout))
+		if _, err := con.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("client wasn't disconnected: %v", err)
+		}
+	}
+}
+
+func TestWebSocketGateway(t *testing.T) {
+	tests := []struct {
+		transport string
+		secure    bool
+		subject   string
+	}{
+		{"ws", false, ""},
+		{"wss", true, "CN=echotest client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			s := echotest.NewServer(t)
+			addr := s.ServeGateway(t, tt.secure)
+			var config *tls.Config
+			if tt.secure {
+				config = s.ClientConfig()
+			}
+
+			con, err := echotest.DialGateway(addr, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.Clients == 1 }); !ok {
+				t.Fatalf("%d clients connected, want 1", st.Clients)
+			}
+			if c := s.Clients()[0]; c.Transport != tt.transport || c.Subject != tt.subject {
+				t.Fatalf("got transport %q and subject %q, want %q and %q", c.Transport, c.Subject, tt.transport, tt.subject)
+			}
+			con.Close()
+
+			r := echotest.RunGatewayClients(t, addr, config, clients, messages, maxSize)
+			if len(r.Errors) > 0 {
+				t.Fatalf("%s, first: %s", r, r.Errors[0])
+			}
+			if want := uint64(clients * messages); r.Messages != want || r.Mismatches > 0 {
+				t.Fatalf("%s, want %d messages and no mismatches", r, want)
+			}
+
+			st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.Clients == 0 })
+			if !ok {
+				t.Fatalf("%d clients still connected", st.Clients)
+			}
+			if st.Accepted != clients+1 {
+				t.Errorf("accepted %d clients, want %d", st.Accepted, clients+1)
+			}
+			if st.BytesReceived != r.WireBytes() || st.BytesSent != r.WireBytes() {
+				t.Errorf("received %d and sent %d bytes, want %d", st.BytesReceived, st.BytesSent, r.WireBytes())
+			}
+		})
+	}
+}
+
+func TestWebSocketGatewayRejectsWhileDraining(t *testing.T) {
+	s := echotest.NewServer(t)
+	addr := s.ServeGateway(t, false)
+	if err := s.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := echotest.DialGateway(addr, nil); !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("got %v, want %v", err, websocket.ErrBadHandshake)
+	}
+}
+
+// clientConfig is the server's client configuration presenting c instead.
+func clientConfig(t *testing.T, s *echotest.Server, c *pki.Certificate) *tls.Config {
+	t.Helper()
+	cert, err := c.TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := s.ClientConfig()
+	config.Certificates = []tls.Certificate{cert}
+	return config
+}
+
+func TestPolicyRejectsClients(t *testing.T) {
+	var (
+		audit   bytes.Buffer
+		revoked *pki.Certificate
+	)
+	s := echotest.NewServerWithPolicy(t, func(ca *pki.CA) *clientauth.Policy {
+		var err error
+		if revoked, err = ca.IssueClient(pkix.Name{CommonName: "revoked client"}, nil, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		crlPEM, err := ca.CreateCRL([]*x509.Certificate{revoked.Cert}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(crlPEM)
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &clientauth.Policy{
+			Deny:  []clientauth.Rule{{Kind: "cn", Value: "denied client"}},
+			CRL:   crl,
+			Audit: log.New(&audit, "", 0)}
+	})
+	denied, err := s.CA.IssueClient(pkix.Name{CommonName: "denied client"}, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, config := range map[string]*tls.Config{"denied": clientConfig(t, s, denied), "revoked": clientConfig(t, s, revoked)} {
+		if r := echotest.RunClients(t, s, config, 1, 1, 0); len(r.Errors) == 0 {
+			t.Errorf("%s client wasn't rejected", name)
+		}
+	}
+	if r := echotest.RunClients(t, s, s.ClientConfig(), 1, 1, 0); len(r.Errors) > 0 {
+		t.Fatalf("allowed client was rejected: %s", r.Errors[0])
+	}
+
+	st, ok := s.WaitFor(waitTimeout, func(st echoserver.Stats) bool { return st.HandshakeFailures == 2 && st.Clients == 0 })
+	if !ok {
+		t.Fatalf("%d failed handshakes and %d clients, want 2 and 0", st.HandshakeFailures, st.Clients)
+	}
+	if st.Accepted != 1 {
+		t.Errorf("accepted %d clients, want 1", st.Accepted)
+	}
+	for _, want := range []string{`denied by cn:denied client (subject "CN=denied client"`, `revoked (subject "CN=revoked client"`} {
+		if !strings.Contains(audit.String(), want) {
+			t.Errorf("audit log lacks %q:\n%s", want, audit.String())
+		}
+	}
+}
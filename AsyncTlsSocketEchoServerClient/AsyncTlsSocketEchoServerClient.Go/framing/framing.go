@@ -0,0 +1,59 @@
+// Package framing implements the length-prefixed message protocol shared by
+// the echo client and server. TCP is a byte stream, so a single read may
+// return part of a message or parts of several. Each frame is therefore a
+// four byte big endian payload length followed by the payload.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HeaderSize is the number of bytes preceding each payload.
+const HeaderSize = 4
+
+// ErrTooLarge is returned for payloads longer than the maximum size.
+var ErrTooLarge = errors.New("frame too large")
+
+// Write writes payload as a single frame. It writes nothing if payload is
+// longer than maxSize bytes.
+func Write(w io.Writer, payload []byte, maxSize int) error {
+	if len(payload) > maxSize {
+		return fmt.Errorf("%w: %d bytes exceeds maximum of %d bytes", ErrTooLarge, len(payload), maxSize)
+	}
+
+	// Header and payload go out in one write so they end up in the same TLS
+	// record.
+	frame := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Read reads a single frame and returns its payload. It returns io.EOF only
+// if no bytes were read and io.ErrUnexpectedEOF if the stream ends within a
+// frame. A header announcing more than maxSize bytes is rejected before the
+// payload is read.
+func Read(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if uint64(size) > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds maximum of %d bytes", ErrTooLarge, size, maxSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,120 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func frame(payload []byte) []byte {
+	var b bytes.Buffer
+	if err := Write(&b, payload, len(payload)); err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	hello := []byte("hello")
+	tests := []struct {
+		name    string
+		input   []byte
+		wrap    func(io.Reader) io.Reader
+		maxSize int
+		want    []byte
+		err     error
+	}{
+		{"payload", frame(hello), nil, 5, hello, nil},
+		{"zero length", frame(nil), nil, 5, []byte{}, nil},
+		{"zero length with zero maximum", frame(nil), nil, 0, []byte{}, nil},
+		{"one byte reads", frame(hello), iotest.OneByteReader, 5, hello, nil},
+		{"half reads", frame(hello), iotest.HalfReader, 5, hello, nil},
+		{"data with EOF", frame(hello), iotest.DataErrReader, 5, hello, nil},
+		{"oversize", frame(hello), nil, 4, nil, ErrTooLarge},
+		{"oversize header only", frame(hello)[:HeaderSize], nil, 4, nil, ErrTooLarge},
+		{"largest header", []byte{0xff, 0xff, 0xff, 0xff}, nil, 1 << 20, nil, ErrTooLarge},
+		{"empty stream", nil, nil, 5, nil, io.EOF},
+		{"short header", frame(hello)[:2], nil, 5, nil, io.ErrUnexpectedEOF},
+		{"header only", frame(hello)[:HeaderSize], nil, 5, nil, io.ErrUnexpectedEOF},
+		{"short payload", frame(hello)[:HeaderSize+2], iotest.OneByteReader, 5, nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r io.Reader = bytes.NewReader(tt.input)
+			if tt.wrap != nil {
+				r = tt.wrap(r)
+			}
+			got, err := Read(r, tt.maxSize)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) || (tt.want != nil && got == nil) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConsecutiveFrames(t *testing.T) {
+	payloads := [][]byte{[]byte("first"), nil, []byte("third")}
+	var stream []byte
+	for _, p := range payloads {
+		stream = append(stream, frame(p)...)
+	}
+	r := iotest.HalfReader(bytes.NewReader(stream))
+	for i, want := range payloads {
+		got, err := Read(r, 16)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("frame %d: got %q, %v, want %q", i, got, err, want)
+		}
+	}
+	if _, err := Read(r, 16); err != io.EOF {
+		t.Fatalf("after last frame got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		maxSize int
+		want    []byte
+		err     error
+	}{
+		{"payload", []byte("hi"), 2, []byte{0, 0, 0, 2, 'h', 'i'}, nil},
+		{"zero length", nil, 0, []byte{0, 0, 0, 0}, nil},
+		{"oversize writes nothing", []byte("hi"), 1, nil, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := Write(&b, tt.payload, tt.maxSize)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !bytes.Equal(b.Bytes(), tt.want) {
+				t.Fatalf("wrote %v, want %v", b.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+// writeCounter counts calls to Write.
+type writeCounter struct{ writes int }
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.writes++
+	return len(b), nil
+}
+
+func TestWriteIsSingleWrite(t *testing.T) {
+	var w writeCounter
+	if err := Write(&w, []byte("hello"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Fatalf("got %d writes, want 1 so header and payload share a TLS record", w.writes)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go
+
+go 1.22
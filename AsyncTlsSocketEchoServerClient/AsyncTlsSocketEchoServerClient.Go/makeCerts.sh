@@ -6,10 +6,10 @@ rm *.crt
 
 # Generate private? key used to sign certificate requests. Anyone holding this
 # key can sign certificates on your behalf.
-openssl genrsa -out bugfree.rootCA.key 2048
+openssl genrsa -out ca.bugfree.dk.key 2048
 
 # Generate and self-sign a root certificate using the previously generated key.
-openssl req -subj "/C=DK/ST=Sjaelland/O=Bugfree Consulting/CN=ca.bugfree.dk" -x509 -new -nodes -days 3650 -key bugfree.rootCA.key -out bugfree.rootCA.crt
+openssl req -subj "/C=DK/ST=Sjaelland/O=Bugfree Consulting/CN=ca.bugfree.dk" -x509 -new -nodes -days 3650 -key ca.bugfree.dk.key -out ca.bugfree.dk.crt
 
 # Generate private key 
 openssl genrsa -out server.bugfree.dk.key 2048
@@ -20,14 +20,16 @@ openssl req -new -subj "/C=DK/ST=Sjaelland/O=Bugfree Consulting/CN=server.bugfre
 openssl req -new -subj "/C=DK/ST=Sjaelland/O=Bugfree Consulting/CN=client.bugfree.dk" -key client.bugfree.dk.key -out client.bugfree.dk.csr
 
 # Generate certificate using the key, signing request, and the CA key and certificate
-openssl x509 -req -in server.bugfree.dk.csr -CA bugfree.rootCA.crt -CAkey bugfree.rootCA.key -CAcreateserial -out server.bugfree.dk.crt -days 3620 -sha256
-openssl x509 -req -in client.bugfree.dk.csr -CA bugfree.rootCA.crt -CAkey bugfree.rootCA.key -CAcreateserial -out client.bugfree.dk.crt -days 3620 -sha256
+# The server certificate needs SANs for the client to verify it.
+printf "subjectAltName=DNS:server.bugfree.dk,DNS:localhost,IP:127.0.0.1,IP:::1" > server.bugfree.dk.ext
+openssl x509 -req -in server.bugfree.dk.csr -CA ca.bugfree.dk.crt -CAkey ca.bugfree.dk.key -CAcreateserial -out server.bugfree.dk.crt -days 3620 -sha256 -extfile server.bugfree.dk.ext
+openssl x509 -req -in client.bugfree.dk.csr -CA ca.bugfree.dk.crt -CAkey ca.bugfree.dk.key -CAcreateserial -out client.bugfree.dk.crt -days 3620 -sha256
 
 # View content of requests
 openssl req -in server.bugfree.dk.csr -text -noout
 openssl req -in client.bugfree.dk.csr -text -noout
 
 # View content of certificates
-openssl x509 -in bugfree.rootCA.crt -text -noout
+openssl x509 -in ca.bugfree.dk.crt -text -noout
 openssl x509 -in server.bugfree.dk.crt -text -noout
 openssl x509 -in client.bugfree.dk.crt -text -noout
@@ -0,0 +1,192 @@
+// Package pki creates a certificate authority and server and client
+// certificates signed by it. It's meant for development and tests, where a
+// throwaway PKI lets the client verify the server rather than skip
+// verification.
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// Certificate is a certificate and its private key, both also PEM encoded.
+type Certificate struct {
+	Cert    *x509.Certificate
+	Key     *ecdsa.PrivateKey
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// TLSCertificate returns the certificate for use in a tls.Config.
+func (c *Certificate) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+}
+
+// WriteFiles writes the PEM encoded certificate and key. The key is only
+// readable by the owner.
+func (c *Certificate) WriteFiles(certFile, keyFile string) error {
+	if err := os.WriteFile(certFile, c.CertPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, c.KeyPEM, 0600)
+}
+
+type CA struct {
+	Certificate
+}
+
+// NewCA creates a self-signed certificate authority.
+func NewCA(subject pkix.Name, validFor time.Duration) (*CA, error) {
+	template := &x509.Certificate{
+		Subject:               subject,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	c, err := create(template, nil, validFor)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{*c}, nil
+}
+
+// LoadCA reads a certificate authority written by WriteFiles.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, errors.New("certificate authority files must be PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("certificate authority key must be ECDSA")
+	}
+	return &CA{Certificate{Cert: cert, Key: ecKey, CertPEM: certPEM, KeyPEM: keyPEM}}, nil
+}
+
+// Pool returns a pool containing only the CA's certificate, for use as
+// RootCAs or ClientCAs in a tls.Config.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// IssueServer creates a certificate for a server reachable by the given host
+// names and IP addresses.
+func (ca *CA) IssueServer(subject pkix.Name, hosts []string, validFor time.Duration) (*Certificate, error) {
+	return ca.issue(subject, hosts, x509.ExtKeyUsageServerAuth, validFor)
+}
+
+// IssueClient creates a certificate for a client. Hosts are optional and
+// only of use to authorization based on SANs.
+func (ca *CA) IssueClient(subject pkix.Name, hosts []string, validFor time.Duration) (*Certificate, error) {
+	return ca.issue(subject, hosts, x509.ExtKeyUsageClientAuth, validFor)
+}
+
+// CreateCRL returns a PEM encoded certificate revocation list revoking the
+// given certificates. Clients must fetch a new list before it expires.
+func (ca *CA) CreateCRL(revoked []*x509.Certificate, validFor time.Duration) ([]byte, error) {
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validFor),
+	}
+	for _, c := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   c.SerialNumber,
+			RevocationTime: now,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+func (ca *CA) issue(subject pkix.Name, hosts []string, usage x509.ExtKeyUsage, validFor time.Duration) (*Certificate, error) {
+	template := &x509.Certificate{
+		Subject:     subject,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	return create(template, ca, validFor)
+}
+
+// create signs template with the CA's key, or self-signs it if ca is nil.
+func create(template *x509.Certificate, ca *CA, validFor time.Duration) (*Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	// Backdating a little allows for clock skew between machines.
+	now := time.Now()
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(validFor)
+
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.Cert, ca.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
@@ -2,7 +2,7 @@ package main
 
 // https://github.com/denji/golang-tls
 
-// [ ] Switch to struct for server
+// [x] Switch to struct for server
 // [ ] Use waitgroup (semaphor) in C# implementation instead of wait
 // [ ] Get debugger working for client and server
 // [ ] Add localhost/127.0.0.1 to certificate using special config file like Ramon does in order to not fail test: https://www.digicert.com/subject-alternative-name.htm
@@ -19,196 +19,262 @@ package main
 // [ ] Test out using OpenSSL library binding
 // [ ] Execute go run -rave server/main.go
 // [ ] Implement proper error handling (https://www.youtube.com/watch?v=lsBF58Q-DnY)
-// [ ] Consider using io.Copy instead of read and write operations
+// [x] Consider using io.Copy instead of read and write operations
 // [ ] Look at https://www.youtube.com/watch?v=5buaPyJ0XeQ, 13:30 for how to avoid mutexes and use channels instead. Look very much like agent main loop in F#. Instead of discriminated using, we switch over channels instead
-// [ ] https://www.yellowduck.be/posts/graceful-shutdown/ for use in client and/or server?
+// [x] https://www.yellowduck.be/posts/graceful-shutdown/ for use in client and/or server?
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"strings"
-	"sync"
-	"sync/atomic"
+	"os/signal"
+	"syscall"
 	"time"
-)
-
-type statistics struct {
-	readOperations  uint64
-	writeOperations uint64
-	bytesReceived   uint64
-	bytesSent       uint64
-}
 
-var (
-	clients     = make(map[string]*tls.Conn)
-	clientsLock = sync.Mutex{}
-	listener    net.Listener
-	stats       statistics
-	wg          = sync.WaitGroup{}
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/certwatch"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/clientauth"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/echoserver"
+	"github.com/ronnieholm/Playground/AsyncTlsSocketEchoServerClient/AsyncTlsSocketEchoServerClient.Go/tlsconfig"
 )
 
-func startServer(endpoint string, config *tls.Config) {
-	defer func() {
-		// Listener may have already been closed so we ignore any error.
-		listener.Close()
+const maxMessageSize = 64 * 1024
 
-		// Gracefully disconnect clients.
-		clientsLock.Lock()
-		defer clientsLock.Unlock()
-		for _, v := range clients {
-			err := v.Close()
-			if err != nil {
-				log.Panic(err)
-			}
-		}
-	}()
+func handlers(bufferSize int) map[string]echoserver.Handler {
+	return map[string]echoserver.Handler{
+		"echo":        echoserver.EchoBuffer(bufferSize),
+		"echo-pooled": echoserver.EchoPooled(echoserver.NewBufferPool(bufferSize)),
+		"echo-copy":   echoserver.EchoCopy(),
+		"discard":     echoserver.Discard(),
+		"chargen":     echoserver.Chargen(),
+		"line":        echoserver.Lines(maxMessageSize, func(line string) string { return line }),
+		"frame":       echoserver.Frames(maxMessageSize, func(payload []byte) []byte { return payload }),
+	}
+}
 
-	var err error
-	listener, err = tls.Listen("tcp4", endpoint, config)
+func main() {
+	modeName := flag.String("mode", "mtls", "tcp, tls for server authentication, or mtls for mutual authentication")
+	minVersion := flag.String("minVersion", "1.2", "minimum TLS version: 1.0, 1.1, 1.2, or 1.3")
+	maxVersion := flag.String("maxVersion", "", "maximum TLS version, or empty for the highest supported")
+	cipherSuites := flag.String("cipherSuites", "", "comma separated TLS 1.2 cipher suites, or empty for Go's defaults")
+	protocols := flag.String("alpn", "echo/1", "comma separated ALPN protocols in order of preference, or empty to not negotiate")
+	sessionTickets := flag.Bool("sessionTickets", true, "issue session tickets so clients can resume sessions with abbreviated handshakes")
+	rootCAFile := flag.String("rootCA", "", "root certificate authority file")
+	serverCertFile := flag.String("serverCertFile", "", "server certificate file")
+	serverKeyFile := flag.String("serverKeyFile", "", "server key file")
+	serverEndpoint := flag.String("server", "127.0.0.1:8080", "server IP and port number")
+	handlerName := flag.String("handler", "echo", "connection handler: echo, echo-pooled, echo-copy, discard, chargen, line, or frame")
+	bufferSize := flag.Int("bufferSize", 1024, "size of the echo and echo-pooled handlers' read buffer")
+	idleTimeout := flag.Duration("idleTimeout", 240*time.Second, "time a client may go without reading or writing, or 0 for forever")
+	readTimeout := flag.Duration("readTimeout", 0, "time a client may go without sending, even while receiving, or 0 for only -idleTimeout")
+	writeTimeout := flag.Duration("writeTimeout", 0, "time a single write to a client may take, or 0 for only -idleTimeout")
+	keepAlive := flag.Duration("keepAlive", 0, "interval of TCP keepalive probes, 0 for the operating system's default, or negative to disable")
+	handshakeTimeout := flag.Duration("handshakeTimeout", 10*time.Second, "time a client has to complete the TLS handshake, and the WebSocket handshake through the gateway")
+	maxConnections := flag.Int("maxConnections", 0, "maximum number of concurrent connections, or 0 for no limit")
+	connectionRate := flag.Float64("ipConnectionRate", 0, "new connections per second per source IP, or 0 for no limit")
+	connectionBurst := flag.Int("ipConnectionBurst", 1, "new connections per source IP allowed in a burst over -ipConnectionRate")
+	byteRate := flag.Float64("ipByteRate", 0, "bytes read per second per source IP, or 0 for no limit")
+	limitPolicy := flag.String("limitPolicy", "reject", "what happens to connections over a limit: reject, queue, or delay")
+	queueTimeout := flag.Duration("queueTimeout", 10*time.Second, "time a queued connection waits before being rejected")
+	statsInterval := flag.Duration("statsInterval", 0, "how often to log statistics, or 0 to never")
+	websocketEndpoint := flag.String("websocket", "", "IP and port number for the WebSocket gateway, using the same -mode, or empty to disable")
+	websocketPath := flag.String("websocketPath", "/echo", "path of the WebSocket gateway")
+	adminEndpoint := flag.String("admin", "", "IP and port number for the HTTP admin endpoint, or empty to disable")
+	allowFile := flag.String("allow", "", "file with rules of which clients to allow, or empty to allow all")
+	denyFile := flag.String("deny", "", "file with rules of which clients to deny, or empty to deny none")
+	crlFile := flag.String("crl", "", "certificate revocation list file, or empty to not check for revoked certificates")
+	auditLogFile := flag.String("auditLog", "", "file to append audit entries for rejected clients to, or empty for stderr")
+	reloadInterval := flag.Duration("reloadInterval", 10*time.Second, "how often to check certificate files for changes, or 0 to never")
+	drain := flag.Duration("drain", 10*time.Second, "time connected clients get to disconnect on shutdown before being disconnected")
+	flag.Parse()
+
+	mode, err := tlsconfig.ParseMode(*modeName)
 	if err != nil {
-		log.Panicf("Unable to listen on endpoint: %s", err)
+		log.Fatal(err)
 	}
-	log.Printf("Listening on %s", listener.Addr())
 
-	for {
-		con, err := listener.Accept()
-		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
-				break
-			}
-			log.Panicf("unable to accept connection: %s", err)
+	// Without TLS, config and watcher remain nil.
+	var (
+		config  *tls.Config
+		watcher *certwatch.Watcher
+	)
+	if mode != tlsconfig.TCP {
+		if *serverCertFile == "" || *serverKeyFile == "" {
+			log.Fatalf("-mode %s needs -serverCertFile and -serverKeyFile", mode)
+		}
+		if mode == tlsconfig.MutualTLS && *rootCAFile == "" {
+			log.Fatalf("-mode %s needs -rootCA to verify clients", mode)
+		}
+		base := &tls.Config{SessionTicketsDisabled: !*sessionTickets}
+		if err := tlsconfig.Apply(base, *minVersion, *maxVersion, *cipherSuites, *protocols); err != nil {
+			log.Fatal(err)
+		}
+		caFile := ""
+		if mode == tlsconfig.MutualTLS {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+			caFile = *rootCAFile
 		}
 
-		tlsCon, ok := con.(*tls.Conn)
-		tlsCon.Handshake()
+		watcher, err = certwatch.New(*serverCertFile, *serverKeyFile, caFile, log.Default())
+		if err != nil {
+			log.Fatal(err)
+		}
+		config = watcher.ServerConfig(base)
 
-		// Under what condition and !ok happen?
-		if ok {
-			state := tlsCon.ConnectionState()
-			for _, v := range state.PeerCertificates {
-				log.Printf("PeerCertificate: %v", v.Subject)
+		if mode == tlsconfig.MutualTLS {
+			policy, err := loadPolicy(*allowFile, *denyFile, *crlFile, *auditLogFile)
+			if err != nil {
+				log.Fatal(err)
 			}
-			log.Printf("HandshakeComplete: %v", state.HandshakeComplete)
-			log.Printf("NegotiatedProtocolIsMutual %v: ", state.NegotiatedProtocolIsMutual)
+			config = policy.Apply(config)
 		}
-
-		go handleEcho(tlsCon)
 	}
-}
 
-func handleEcho(con *tls.Conn) {
-	wg.Add(1)
-	s := statistics{}
-	defer func() {
-		wg.Done()
-
-		// It may already have been closed so we ignore any error.
-		con.Close()
-
-		atomic.AddUint64(&stats.readOperations, s.readOperations)
-		atomic.AddUint64(&stats.writeOperations, s.writeOperations)
-		atomic.AddUint64(&stats.bytesReceived, s.bytesReceived)
-		atomic.AddUint64(&stats.bytesSent, s.bytesSent)
-
-		clientsLock.Lock()
-		defer clientsLock.Unlock()
-		addr := con.RemoteAddr().String()
-		delete(clients, addr)
-		log.Printf("Disconnected client %s (clients: %d)", addr, len(clients))
-	}()
-
-	resetTimeout := func() {
-		timeoutDuration := 240 * time.Second
-		err := con.SetDeadline(time.Now().Add(timeoutDuration))
-		if err != nil {
-			log.Panicf("Unable to set deadline: %s", err)
-		}
+	handler, ok := handlers(*bufferSize)[*handlerName]
+	if !ok {
+		log.Fatalf("unknown handler: %s", *handlerName)
 	}
 
-	clientsLock.Lock()
-	clients[con.RemoteAddr().String()] = con
-	log.Printf("Accepted client from %s (clients: %d)", con.RemoteAddr(), len(clients))
-	clientsLock.Unlock()
+	policyValue, err := echoserver.ParseLimitPolicy(*limitPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits := echoserver.Limits{
+		MaxConnections:  *maxConnections,
+		ConnectionRate:  *connectionRate,
+		ConnectionBurst: *connectionBurst,
+		ByteRate:        *byteRate,
+		Policy:          policyValue,
+		QueueTimeout:    *queueTimeout}
 
-	buf := make([]byte, 1024)
-	resetTimeout()
+	server := echoserver.New(
+		echoserver.WithAddr(*serverEndpoint),
+		echoserver.WithTLSConfig(config),
+		echoserver.WithIdleTimeout(*idleTimeout),
+		echoserver.WithReadTimeout(*readTimeout),
+		echoserver.WithWriteTimeout(*writeTimeout),
+		echoserver.WithKeepAlive(*keepAlive),
+		echoserver.WithHandshakeTimeout(*handshakeTimeout),
+		echoserver.WithLimits(limits),
+		echoserver.WithHandler(handler))
+	if err := server.Start(); err != nil {
+		log.Fatalf("Unable to listen on endpoint: %s", err)
+	}
 
-	for {
-		n, err := con.Read(buf)
-		if n != 0 {
-			s.readOperations++
-			s.bytesReceived += uint64(n)
-		}
+	if *websocketEndpoint != "" {
+		listener, err := net.Listen("tcp4", *websocketEndpoint)
 		if err != nil {
-			log.Printf("Error on read: %s", err)
-			return
+			log.Fatalf("Unable to listen on WebSocket endpoint: %s", err)
 		}
-		resetTimeout()
-
-		m, err := con.Write(buf[:n])
-		if m != 0 {
-			s.writeOperations++
-			s.bytesSent += uint64(m)
+		scheme := "ws"
+		if config != nil {
+			listener = tls.NewListener(listener, tlsconfig.WithProtocols(config, "http/1.1"))
+			scheme = "wss"
 		}
-		if err != nil {
-			log.Printf("Error on write: %s", err)
-			return
+		mux := http.NewServeMux()
+		mux.Handle(*websocketPath, server.WebSocketHandler())
+		// The TLS handshake and the WebSocket handshake's request get the
+		// handshake timeout together. The upgraded connection is then
+		// subject to the server's timeouts.
+		gateway := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: *handshakeTimeout,
 		}
-		resetTimeout()
+		go func() {
+			log.Printf("WebSocket gateway listening on %s://%s%s", scheme, *websocketEndpoint, *websocketPath)
+			if err := gateway.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Fatalf("Unable to serve WebSocket gateway: %s", err)
+			}
+		}()
 	}
-}
-
-func stopServer() {
-	listener.Close()
-	wg.Wait()
-}
-
-func main() {
-	rootCAFile := flag.String("rootCA", "", "root certificate authority file")
-	serverCertFile := flag.String("serverCertFile", "", "server certificate file")
-	serverKeyFile := flag.String("serverKeyFile", "", "server key file")
-	serverEndpoint := flag.String("server", "127.0.0.1:8080", "server IP and port number")
-	flag.Parse()
 
-	certPool := x509.NewCertPool()
-	rootCA, err := ioutil.ReadFile(*rootCAFile)
-	if err != nil {
-		log.Fatalf("failed to load root certificate: %s", *rootCAFile)
+	if *adminEndpoint != "" {
+		go func() {
+			log.Printf("Admin endpoint listening on %s", *adminEndpoint)
+			if err := http.ListenAndServe(*adminEndpoint, server.AdminHandler()); err != nil {
+				log.Fatalf("Unable to serve admin endpoint: %s", err)
+			}
+		}()
 	}
 
-	ok := certPool.AppendCertsFromPEM(rootCA)
-	if !ok {
-		log.Fatalf("failed to add certificate to pool: %s", *rootCAFile)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if *statsInterval > 0 {
+		go logStats(ctx, server, *statsInterval)
 	}
-
-	cert, err := tls.LoadX509KeyPair(*serverCertFile, *serverKeyFile)
-	if err != nil {
-		log.Fatalf("unable to load server certificate: %s", err)
+	if watcher != nil && *reloadInterval > 0 {
+		go watcher.Watch(ctx, *reloadInterval)
 	}
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down, draining clients for up to %s", *drain)
 
-	config := tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    certPool}
-
-	b := make([]byte, 1)
-	go startServer(*serverEndpoint, &config)
-	println("Press any key to stop server")
-	os.Stdin.Read(b)
+	ctx, cancel := context.WithTimeout(context.Background(), *drain)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		log.Printf("Drain period expired: %s", err)
+	}
 
-	stopServer()
+	stats := server.Stats()
+	log.Printf("Accepted clients: %d", stats.Accepted)
+	log.Printf("Failed handshakes: %d", stats.HandshakeFailures)
+	log.Printf("Idle timeouts: %d", stats.IdleTimeouts)
+	log.Printf("Read timeouts: %d", stats.ReadTimeouts)
+	log.Printf("Write timeouts: %d", stats.WriteTimeouts)
+	log.Printf("Connections rejected by limits: %d", stats.LimitRejections)
+	log.Printf("Connections waiting on limits: %d", stats.LimitWaits)
+	log.Printf("Reads throttled: %d", stats.ThrottledReads)
+	log.Printf("Read operations: %d", stats.ReadOperations)
+	log.Printf("Write operations: %d", stats.WriteOperations)
+	log.Printf("Bytes receiver operations: %d", stats.BytesReceived)
+	log.Printf("Bytes sent operations: %d", stats.BytesSent)
+}
 
-	log.Printf("Read operations: %d", stats.readOperations)
-	log.Printf("Write operations: %d", stats.writeOperations)
-	log.Printf("Bytes receiver operations: %d", stats.bytesReceived)
-	log.Printf("Bytes sent operations: %d", stats.bytesSent)
+func logStats(ctx context.Context, server *echoserver.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			st := server.Stats()
+			log.Printf("Clients: %d, accepted: %d, failed handshakes: %d, reads: %d, writes: %d, bytes received: %d, bytes sent: %d, timeouts (idle/read/write): %d/%d/%d, limit rejections: %d, limit waits: %d, throttled reads: %d",
+				st.Clients, st.Accepted, st.HandshakeFailures, st.ReadOperations, st.WriteOperations, st.BytesReceived, st.BytesSent,
+				st.IdleTimeouts, st.ReadTimeouts, st.WriteTimeouts,
+				st.LimitRejections, st.LimitWaits, st.ThrottledReads)
+		}
+	}
+}
 
-	println("Press any key to exit process")
-	os.Stdin.Read(b)
+func loadPolicy(allowFile, denyFile, crlFile, auditLogFile string) (*clientauth.Policy, error) {
+	policy := &clientauth.Policy{Audit: log.New(os.Stderr, "AUDIT ", log.LstdFlags)}
+	var err error
+	if allowFile != "" {
+		if policy.Allow, err = clientauth.ReadRules(allowFile); err != nil {
+			return nil, err
+		}
+	}
+	if denyFile != "" {
+		if policy.Deny, err = clientauth.ReadRules(denyFile); err != nil {
+			return nil, err
+		}
+	}
+	if crlFile != "" {
+		if policy.CRL, err = clientauth.ReadCRL(crlFile); err != nil {
+			return nil, fmt.Errorf("unable to load certificate revocation list: %w", err)
+		}
+	}
+	if auditLogFile != "" {
+		f, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		policy.Audit.SetOutput(f)
+	}
+	return policy, nil
 }
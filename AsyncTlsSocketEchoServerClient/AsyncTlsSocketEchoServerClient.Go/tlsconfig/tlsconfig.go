@@ -0,0 +1,143 @@
+// Package tlsconfig parses the command-line flags shared by the echo server
+// and client for choosing between plain TCP, TLS, and mutual TLS, and which
+// TLS versions, cipher suites, and application protocols to allow.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+type Mode int
+
+const (
+	// TCP sends everything in the clear.
+	TCP Mode = iota
+	// TLS authenticates the server only.
+	TLS
+	// MutualTLS authenticates both server and client.
+	MutualTLS
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "tcp":
+		return TCP, nil
+	case "tls":
+		return TLS, nil
+	case "mtls":
+		return MutualTLS, nil
+	}
+	return 0, fmt.Errorf("unknown mode: %s", s)
+}
+
+func (m Mode) String() string {
+	switch m {
+	case TCP:
+		return "tcp"
+	case TLS:
+		return "tls"
+	case MutualTLS:
+		return "mtls"
+	}
+	return "unknown"
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion parses a TLS version such as 1.2. The empty string means
+// crypto/tls's default and is returned as zero.
+func ParseVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := versions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version: %s", s)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names,
+// such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. The empty string means
+// crypto/tls's default and is returned as nil. Only TLS 1.2 and earlier
+// are affected, as TLS 1.3 suites aren't configurable.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		known[c.Name] = c.ID
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		known[c.Name] = c.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseProtocols parses a comma separated list of ALPN protocol names,
+// such as echo/1. The empty string means no ALPN and is returned as nil.
+func ParseProtocols(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var protocols []string
+	for _, p := range strings.Split(s, ",") {
+		protocols = append(protocols, strings.TrimSpace(p))
+	}
+	return protocols
+}
+
+// Apply sets the versions, cipher suites, and ALPN protocols parsed from
+// flags on config.
+func Apply(config *tls.Config, minVersion, maxVersion, cipherSuites, protocols string) error {
+	var err error
+	if config.MinVersion, err = ParseVersion(minVersion); err != nil {
+		return err
+	}
+	if config.MaxVersion, err = ParseVersion(maxVersion); err != nil {
+		return err
+	}
+	if config.CipherSuites, err = ParseCipherSuites(cipherSuites); err != nil {
+		return err
+	}
+	config.NextProtos = ParseProtocols(protocols)
+	return nil
+}
+
+// WithProtocols returns a copy of config negotiating protocols with ALPN
+// instead, including in configs returned by its GetConfigForClient. It lets
+// another listener, such as an HTTP one, share config.
+func WithProtocols(config *tls.Config, protocols ...string) *tls.Config {
+	c := config.Clone()
+	c.NextProtos = protocols
+	if inner := config.GetConfigForClient; inner != nil {
+		c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			perClient, err := inner(hello)
+			if err != nil || perClient == nil {
+				return perClient, err
+			}
+			perClient = perClient.Clone()
+			perClient.NextProtos = protocols
+			return perClient, nil
+		}
+	}
+	return c
+}
@@ -0,0 +1,339 @@
+// Package websocket implements enough of the WebSocket protocol (RFC 6455)
+// to carry a byte stream. Each Write is sent as a binary message and Read
+// returns the payloads of received messages back to back, so a WebSocket
+// connection can stand in for a TCP or TLS connection. Extensions and
+// subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the client's key to prove the server speaks WebSocket.
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxFrameSize is the largest frame payload accepted. Larger frames close
+// the connection.
+const MaxFrameSize = 1 << 20
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrProtocol     = errors.New("websocket: protocol error")
+	ErrTooLarge     = errors.New("websocket: frame too large")
+)
+
+// Conn is a WebSocket connection over another connection, such as a TLS
+// connection. Deadlines and addresses are those of the underlying
+// connection. Read must not be called concurrently, but Write may be called
+// concurrently with Read.
+type Conn struct {
+	net.Conn
+	br     *bufio.Reader // May hold data read past the handshake.
+	client bool          // Clients mask what they send, servers don't.
+
+	// Of the data frame being read.
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	writeLock sync.Mutex
+	closeSent bool
+}
+
+// Upgrade completes the opening handshake of a WebSocket client and takes
+// over its connection. On failure, it has already replied with an error.
+// It doesn't check the Origin header, so any web page may connect.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "not a WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		http.Error(w, "invalid WebSocket key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be taken over", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+
+	con, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		con.Close()
+		return nil, err
+	}
+	return &Conn{Conn: con, br: rw.Reader}, nil
+}
+
+// Client completes the opening handshake with the server at the other end
+// of con, which may be a TLS connection, for the resource at path. Host is
+// sent as the Host header.
+func Client(con net.Conn, host, path string) (*Conn, error) {
+	k := make([]byte, 16)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(k)
+	_, err := fmt.Fprintf(con, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, host, key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(con)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+	return &Conn{Conn: con, br: br, client: true}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read reads the payload of data frames, answering pings along the way. It
+// returns io.EOF once the peer has sent a close frame.
+func (c *Conn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	if c.masked {
+		for i := range b[:n] {
+			b[i] ^= c.mask[c.maskPos]
+			c.maskPos = (c.maskPos + 1) % 4
+		}
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers, handling control frames, until the start
+// of a data frame.
+func (c *Conn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	fin := header[0]&0x80 != 0
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	// Reserved bits are only for extensions. Clients must mask and servers
+	// mustn't.
+	if header[0]&0x70 != 0 || masked == c.client {
+		return c.fail(closeProtocolError, ErrProtocol)
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > MaxFrameSize {
+		return c.fail(closeTooBig, ErrTooLarge)
+	}
+	c.masked = masked
+	c.maskPos = 0
+	if masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+	default:
+		return c.fail(closeProtocolError, ErrProtocol)
+	}
+
+	if !fin || length > 125 {
+		return c.fail(closeProtocolError, ErrProtocol)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+	}
+	switch opcode {
+	case opClose:
+		// Echoing the status code acknowledges the close.
+		if len(payload) >= 2 {
+			payload = payload[:2]
+		}
+		c.writeClose(payload)
+		return io.EOF
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	}
+	return nil
+}
+
+// fail closes the connection with code because of err.
+func (c *Conn) fail(code uint16, err error) error {
+	c.writeClose(binary.BigEndian.AppendUint16(nil, code))
+	c.Conn.Close()
+	return err
+}
+
+// writeClose sends a close frame unless one has been sent already. It's
+// best effort, so errors are ignored.
+func (c *Conn) writeClose(payload []byte) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return
+	}
+	c.closeSent = true
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrameLocked(opClose, payload)
+}
+
+// Write sends b as a single binary message.
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(opBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes the frame with a single write, so it's a single
+// record over TLS. Callers must hold the write lock.
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if !c.client {
+		frame = append(frame, payload...)
+		_, err := c.Conn.Write(frame)
+		return err
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame and closes the underlying connection without
+// waiting for the peer to acknowledge. If a write is in progress, possibly
+// blocked on a slow peer, the close frame is skipped rather than waited on.
+func (c *Conn) Close() error {
+	if c.writeLock.TryLock() {
+		if !c.closeSent {
+			c.closeSent = true
+			c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			c.writeFrameLocked(opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+		}
+		c.writeLock.Unlock()
+	}
+	return c.Conn.Close()
+}
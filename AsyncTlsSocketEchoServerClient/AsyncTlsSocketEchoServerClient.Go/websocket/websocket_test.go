@@ -0,0 +1,420 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pair connects two TCP connections over loopback, which unlike net.Pipe
+// buffer, so a peer's reply doesn't block until it's read.
+func pair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		con, _ := listener.Accept()
+		accepted <- con
+	}()
+	a, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := <-accepted
+	if b == nil {
+		t.Fatal("unable to accept connection")
+	}
+	for _, con := range []net.Conn{a, b} {
+		con.SetDeadline(time.Now().Add(10 * time.Second))
+		t.Cleanup(func() { con.Close() })
+	}
+	return a, b
+}
+
+// server wraps con as the server's end of a connection whose handshake has
+// completed. Its peer is the raw connection at the other end.
+func server(con net.Conn) *Conn {
+	return &Conn{Conn: con, br: bufio.NewReader(con)}
+}
+
+func client(con net.Conn) *Conn {
+	return &Conn{Conn: con, br: bufio.NewReader(con), client: true}
+}
+
+// frame encodes a frame with the given first byte, i.e., FIN, reserved bits
+// and opcode, masking the payload with a fixed key if masked.
+func frame(first byte, masked bool, payload []byte) []byte {
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	b := []byte{first}
+	switch {
+	case len(payload) <= 125:
+		b = append(b, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		b = binary.BigEndian.AppendUint16(append(b, maskBit|126), uint16(len(payload)))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, maskBit|127), uint64(len(payload)))
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+// readFrame decodes a frame, returning its first byte, whether it's masked,
+// and its unmasked payload.
+func readFrame(t *testing.T, r io.Reader) (byte, bool, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	masked := header[1]&0x80 != 0
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0], masked, payload
+}
+
+func write(t *testing.T, w io.Writer, frames ...[]byte) {
+	t.Helper()
+	for _, f := range frames {
+		if _, err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func closeCode(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		io.Copy(con, con)
+	}))
+	defer s.Close()
+
+	raw, err := net.Dial("tcp4", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.SetDeadline(time.Now().Add(10 * time.Second))
+	con, err := Client(raw, s.Listener.Addr().String(), "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	for _, message := range []string{"hello", "world"} {
+		if _, err := con.Write([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(message))
+		if _, err := io.ReadFull(con, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != message {
+			t.Fatalf("got %q, want %q", got, message)
+		}
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	valid := map[string]string{
+		"Connection":            "keep-alive, Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+	with := func(name, value string) map[string]string {
+		h := map[string]string{}
+		for k, v := range valid {
+			h[k] = v
+		}
+		h[name] = value
+		return h
+	}
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		status int
+	}{
+		{"not an upgrade", http.MethodGet, nil, http.StatusBadRequest},
+		{"post", http.MethodPost, valid, http.StatusBadRequest},
+		{"other upgrade", http.MethodGet, with("Upgrade", "h2c"), http.StatusBadRequest},
+		{"old version", http.MethodGet, with("Sec-WebSocket-Version", "8"), http.StatusUpgradeRequired},
+		{"missing key", http.MethodGet, with("Sec-WebSocket-Key", ""), http.StatusBadRequest},
+		{"short key", http.MethodGet, with("Sec-WebSocket-Key", "c2hvcnQ="), http.StatusBadRequest},
+		// A ResponseRecorder can't be hijacked.
+		{"not hijackable", http.MethodGet, valid, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if _, err := Upgrade(w, r); !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("got error %v, want %v", err, ErrBadHandshake)
+			}
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestClientRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(key string) string
+	}{
+		{"wrong accept", func(string) string {
+			return "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n"
+		}},
+		{"not switching", func(key string) string {
+			return "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := pair(t)
+			go func() {
+				r, err := http.ReadRequest(bufio.NewReader(b))
+				if err != nil {
+					return
+				}
+				fmt.Fprint(b, tt.response(r.Header.Get("Sec-WebSocket-Key")))
+			}()
+			if _, err := Client(a, "example.com", "/"); !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("got error %v, want %v", err, ErrBadHandshake)
+			}
+		})
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for _, size := range []int{0, 1, 125, 126, 0xffff, 0x10000, MaxFrameSize} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			a, b := pair(t)
+			c, s := client(a), server(b)
+			payload := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+
+			// Writes may block until the peer reads.
+			errs := make(chan error, 2)
+			go func() { _, err := c.Write(payload); errs <- err }()
+			go func() { _, err := s.Write(payload); errs <- err }()
+			for name, con := range map[string]*Conn{"server": s, "client": c} {
+				got := make([]byte, size)
+				if _, err := io.ReadFull(con, got); err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Fatalf("%s read a different payload", name)
+				}
+			}
+			for range 2 {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		con    func(net.Conn) *Conn
+		size   int
+		header []byte
+	}{
+		{"server 7-bit length", server, 125, []byte{0x82, 125}},
+		{"server 16-bit length", server, 126, []byte{0x82, 126, 0, 126}},
+		{"server 64-bit length", server, 0x10000, []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+		{"client masks", client, 5, []byte{0x82, 0x80 | 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := pair(t)
+			payload := bytes.Repeat([]byte{'x'}, tt.size)
+			go tt.con(a).Write(payload)
+
+			header := make([]byte, len(tt.header))
+			if _, err := io.ReadFull(b, header); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(header, tt.header) {
+				t.Fatalf("got header % x, want % x", header, tt.header)
+			}
+			if tt.header[1]&0x80 != 0 {
+				io.ReadFull(b, make([]byte, 4))
+			}
+			got := make([]byte, tt.size)
+			if _, err := io.ReadFull(b, got); err != nil {
+				t.Fatal(err)
+			}
+			if masked := !bytes.Equal(got, payload); masked != (tt.header[1]&0x80 != 0) {
+				t.Fatalf("payload masked: %v", masked)
+			}
+		})
+	}
+}
+
+// TestFragmented has a client send a message in fragments with pings in
+// between, which the server answers without disturbing the message.
+func TestFragmented(t *testing.T) {
+	a, b := pair(t)
+	s := server(b)
+	write(t, a,
+		frame(opBinary, true, []byte("hel")),
+		frame(0x80|opPing, true, []byte("first")),
+		frame(opContinuation, true, []byte("lo ")),
+		frame(0x80|opPing, true, nil),
+		frame(0x80|opPong, true, []byte("unsolicited")),
+		frame(0x80|opContinuation, true, []byte("world")),
+		frame(0x80|opText, true, []byte("!")))
+
+	got := make([]byte, len("hello world!"))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world!" {
+		t.Fatalf("got %q", got)
+	}
+	for _, want := range []string{"first", ""} {
+		first, masked, payload := readFrame(t, a)
+		if first != 0x80|opPong || masked || string(payload) != want {
+			t.Fatalf("got frame %#x, masked %v, payload %q, want pong %q", first, masked, payload, want)
+		}
+	}
+}
+
+// TestReadRejects has a peer break the protocol, after which the
+// connection is closed with a status code.
+func TestReadRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		con   func(net.Conn) *Conn
+		frame []byte
+		err   error
+		code  uint16
+	}{
+		{"unmasked from client", server, frame(0x80|opBinary, false, []byte("x")), ErrProtocol, closeProtocolError},
+		{"masked from server", client, frame(0x80|opBinary, true, []byte("x")), ErrProtocol, closeProtocolError},
+		{"reserved bit", server, frame(0xc0|opBinary, true, []byte("x")), ErrProtocol, closeProtocolError},
+		{"unknown opcode", server, frame(0x80|0x3, true, []byte("x")), ErrProtocol, closeProtocolError},
+		{"fragmented ping", server, frame(opPing, true, []byte("x")), ErrProtocol, closeProtocolError},
+		{"long ping", server, frame(0x80|opPing, true, make([]byte, 126)), ErrProtocol, closeProtocolError},
+		{"oversized", server, binary.BigEndian.AppendUint64([]byte{0x80 | opBinary, 0x80 | 127}, MaxFrameSize+1), ErrTooLarge, closeTooBig},
+		{"largest length", server, []byte{0x80 | opBinary, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, ErrTooLarge, closeTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := pair(t)
+			con := tt.con(b)
+			write(t, a, tt.frame)
+
+			if _, err := con.Read(make([]byte, 1)); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			first, _, payload := readFrame(t, a)
+			if first != 0x80|opClose || !bytes.Equal(payload, closeCode(tt.code)) {
+				t.Fatalf("got frame %#x with payload % x, want close %d", first, payload, tt.code)
+			}
+			if _, err := a.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("got %v, want connection closed", err)
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Run("by peer", func(t *testing.T) {
+		a, b := pair(t)
+		s := server(b)
+		write(t, a, frame(0x80|opClose, true, append(closeCode(closeNormal), "bye"...)))
+
+		if _, err := s.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("got %v, want %v", err, io.EOF)
+		}
+		// The close is acknowledged with the status code only.
+		first, _, payload := readFrame(t, a)
+		if first != 0x80|opClose || !bytes.Equal(payload, closeCode(closeNormal)) {
+			t.Fatalf("got frame %#x with payload % x", first, payload)
+		}
+		if _, err := s.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("write after close: got %v, want %v", err, net.ErrClosed)
+		}
+	})
+
+	t.Run("by us", func(t *testing.T) {
+		a, b := pair(t)
+		if err := client(a).Close(); err != nil {
+			t.Fatal(err)
+		}
+		first, masked, payload := readFrame(t, b)
+		if first != 0x80|opClose || !masked || !bytes.Equal(payload, closeCode(closeNormal)) {
+			t.Fatalf("got frame %#x, masked %v, payload % x", first, masked, payload)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package authorization
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+type Operation string
+
+const (
+	ReadProduct  Operation = "product:read"
+	WriteProduct Operation = "product:write"
+)
+
+// Principal is the authenticated caller. It's established at the edge, e.g.,
+// from a bearer token, and passed into queries and commands as is.
+type Principal struct {
+	Subject    string
+	Operations []Operation
+	Scopes     []string
+}
+
+func (p Principal) Can(op Operation) bool {
+	for _, o := range p.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ScopeMode int
+
+const (
+	// RejectScopes fails the request if any requested scope isn't granted.
+	RejectScopes ScopeMode = iota
+	// TrimScopes drops requested scopes which aren't granted and carries on
+	// with the remaining ones.
+	TrimScopes
+)
+
+type Policy struct {
+	ScopeMode ScopeMode
+}
+
+// Authorize checks that principal may perform op on the requested scopes and
+// returns the scopes to pass on to ProductInformation. Scopes are strings, not
+// product.Scope, as authorization happens ahead of validation.
+func (p Policy) Authorize(principal Principal, op Operation, scopes []string) ([]string, []error) {
+	if !principal.Can(op) {
+		return nil, []error{fmt.Errorf("%w: %q may not %s", ErrForbidden, principal.Subject, op)}
+	}
+
+	errors := []error{}
+	allowed := []string{}
+	for _, scope := range scopes {
+		if principal.HasScope(scope) {
+			allowed = append(allowed, scope)
+		} else if p.ScopeMode == RejectScopes {
+			errors = append(errors, fmt.Errorf("%w: %q may not access scope %q", ErrForbidden, principal.Subject, scope))
+		}
+	}
+
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return allowed, nil
+}
+
+// Visible returns those of scopes which principal is granted, so reads don't
+// reveal scopes the principal can't access.
+func (p Policy) Visible(principal Principal, scopes []string) []string {
+	visible := []string{}
+	for _, scope := range scopes {
+		if principal.HasScope(scope) {
+			visible = append(visible, scope)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"example.com/m/metrics"
+	"example.com/m/tracing"
+)
+
+var (
+	operations = metrics.Default.Counter(
+		"product_operations_total",
+		"Queries and commands run, by outcome.",
+		"operation", "kind", "outcome")
+	operationDuration = metrics.Default.Histogram(
+		"product_operation_duration_seconds",
+		"Duration of queries and commands.",
+		metrics.DefaultBuckets,
+		"operation", "kind")
+	validationErrors = metrics.Default.Counter(
+		"product_validation_errors_total",
+		"Validation errors reported by queries and commands, by field.",
+		"operation", "field")
+)
+
+var outcomes = map[ErrorKind]string{
+	InternalError:   "error",
+	InvalidInput:    "invalid",
+	Forbidden:       "forbidden",
+	NotFound:        "not_found",
+	UpstreamFailure: "upstream_error",
+}
+
+// Observe starts a span for a query or command and returns a function which
+// ends the span and records the outcome in metrics. Call it like so:
+//
+//	ctx, done := application.Observe(ctx, "GetProductByIdQuery")
+//	defer func() { done(errs) }()
+func Observe(ctx context.Context, operation string) (context.Context, func([]error)) {
+	kind := "query"
+	if strings.HasSuffix(operation, "Command") {
+		kind = "command"
+	}
+
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "application", operation)
+	return ctx, func(errs []error) {
+		span.End(len(errs))
+		operationDuration.Observe(time.Since(start).Seconds(), operation, kind)
+
+		outcome := "ok"
+		if len(errs) > 0 {
+			outcome = outcomes[Classify(errs)]
+		}
+		operations.Inc(operation, kind, outcome)
+
+		for _, err := range errs {
+			var v ValidationError
+			if errors.As(err, &v) {
+				validationErrors.Inc(operation, v.Field)
+			}
+		}
+	}
+}
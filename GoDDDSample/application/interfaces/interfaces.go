@@ -1,8 +1,30 @@
 package interfaces
 
-import "example.com/m/domain/product"
+import (
+	"context"
+	"errors"
+
+	"example.com/m/domain/product"
+)
+
+var ErrProductNotFound = errors.New("product not found")
 
 type ProductInformation interface {
-	GetProductIds() ([]product.ExternalProductId, []error)
-	GetProductById(id product.ExternalProductId, scopes []product.Scope) (product.Product, []error)
+	GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error)
+	GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error)
+}
+
+// ProductRepository is the local store of products. SaveProduct persists the
+// product and appends its recorded events to the product's history.
+type ProductRepository interface {
+	GetProducts(ctx context.Context) ([]product.Product, []error)
+	GetProduct(ctx context.Context, id product.ExternalProductId) (product.Product, []error)
+	SaveProduct(ctx context.Context, p product.Product) []error
+	GetProductHistory(ctx context.Context, id product.ExternalProductId) ([]product.Event, []error)
+}
+
+// EventHandler reacts to domain events once the aggregate recording them has
+// been saved.
+type EventHandler interface {
+	Handle(ctx context.Context, e product.Event) []error
 }
@@ -0,0 +1,198 @@
+// Package interfacestest holds test helpers for implementations of the
+// application layer interfaces.
+package interfacestest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+// Fixture tells the contract suite about the data behind the implementation
+// under test. Known must be retrievable, Unknown must not exist, and Scopes
+// must be valid scopes for the known products.
+type Fixture struct {
+	Known   []product.ExternalProductId
+	Unknown product.ExternalProductId
+	Scopes  []product.Scope
+
+	// Concurrency is the number of simultaneous callers. Defaults to 16.
+	Concurrency int
+}
+
+// TestProductInformation runs the ProductInformation contract against pi and
+// returns an error describing every violation, or nil. Like fstest.TestFS, it
+// doesn't depend on package testing:
+//
+//	if err := interfacestest.TestProductInformation(client, fixture); err != nil {
+//		t.Fatal(err)
+//	}
+func TestProductInformation(pi interfaces.ProductInformation, fixture Fixture) error {
+	c := &contract{ctx: context.Background(), pi: pi, fixture: fixture}
+	if c.fixture.Concurrency == 0 {
+		c.fixture.Concurrency = 16
+	}
+
+	c.checkProductIds()
+	for _, id := range fixture.Known {
+		c.checkKnown(id)
+		c.checkScopeFiltering(id)
+	}
+	c.checkUnknown()
+	c.checkConcurrency()
+
+	if len(c.violations) == 0 {
+		return nil
+	}
+	return errors.New("ProductInformation contract violated:\n\t" + strings.Join(c.violations, "\n\t"))
+}
+
+type contract struct {
+	ctx        context.Context
+	pi         interfaces.ProductInformation
+	fixture    Fixture
+	mu         sync.Mutex
+	violations []string
+}
+
+func (c *contract) errorf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.violations = append(c.violations, fmt.Sprintf(format, args...))
+}
+
+func (c *contract) checkProductIds() {
+	ids, errs := c.pi.GetProductIds(c.ctx)
+	if !c.checkErrorShape("GetProductIds", errs) {
+		return
+	}
+	if len(errs) > 0 {
+		c.errorf("GetProductIds: unexpected errors: %v", errs)
+		return
+	}
+	for _, known := range c.fixture.Known {
+		found := false
+		for _, id := range ids {
+			if id.Equals(known) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.errorf("GetProductIds: known id %q missing", known.Value())
+		}
+	}
+}
+
+func (c *contract) checkKnown(id product.ExternalProductId) {
+	p, errs := c.pi.GetProductById(c.ctx, id, nil)
+	if !c.checkErrorShape("GetProductById", errs) {
+		return
+	}
+	if len(errs) > 0 {
+		c.errorf("GetProductById(%q): unexpected errors: %v", id.Value(), errs)
+		return
+	}
+	if !p.ExternalId().Equals(id) {
+		c.errorf("GetProductById(%q): returned product %q", id.Value(), p.ExternalId().Value())
+	}
+}
+
+func (c *contract) checkScopeFiltering(id product.ExternalProductId) {
+	p, errs := c.pi.GetProductById(c.ctx, id, nil)
+	if len(errs) == 0 && len(p.Scopes()) != 0 {
+		c.errorf("GetProductById(%q): returned scopes %v without any requested", id.Value(), scopeValues(p.Scopes()))
+	}
+
+	for _, scope := range c.fixture.Scopes {
+		requested := []product.Scope{scope}
+		p, errs := c.pi.GetProductById(c.ctx, id, requested)
+		if len(errs) > 0 {
+			c.errorf("GetProductById(%q, %q): unexpected errors: %v", id.Value(), scope.Value(), errs)
+			continue
+		}
+		for _, s := range p.Scopes() {
+			if !s.Equals(scope) {
+				c.errorf("GetProductById(%q, %q): returned unrequested scope %q", id.Value(), scope.Value(), s.Value())
+			}
+		}
+	}
+}
+
+func (c *contract) checkUnknown() {
+	id := c.fixture.Unknown
+	p, errs := c.pi.GetProductById(c.ctx, id, c.fixture.Scopes)
+	if !c.checkErrorShape("GetProductById", errs) {
+		return
+	}
+	if len(errs) == 0 {
+		c.errorf("GetProductById(%q): unknown id returned product %q", id.Value(), p.ExternalId().Value())
+		return
+	}
+	if !p.ExternalId().Equals(product.ExternalProductId{}) {
+		c.errorf("GetProductById(%q): returned non-zero product alongside errors", id.Value())
+	}
+	found := false
+	for _, err := range errs {
+		if errors.Is(err, interfaces.ErrProductNotFound) {
+			found = true
+		}
+	}
+	if !found {
+		c.errorf("GetProductById(%q): errors %v don't wrap interfaces.ErrProductNotFound", id.Value(), errs)
+	}
+}
+
+func (c *contract) checkConcurrency() {
+	wg := sync.WaitGroup{}
+	for i := 0; i < c.fixture.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if _, errs := c.pi.GetProductIds(c.ctx); len(errs) > 0 {
+					c.errorf("concurrent GetProductIds: unexpected errors: %v", errs)
+				}
+				return
+			}
+			for _, id := range c.fixture.Known {
+				p, errs := c.pi.GetProductById(c.ctx, id, c.fixture.Scopes)
+				if len(errs) > 0 {
+					c.errorf("concurrent GetProductById(%q): unexpected errors: %v", id.Value(), errs)
+				} else if !p.ExternalId().Equals(id) {
+					c.errorf("concurrent GetProductById(%q): returned product %q", id.Value(), p.ExternalId().Value())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// checkErrorShape verifies that errors are reported as a nil or non-empty
+// slice without nil entries, so callers can rely on len(errs) > 0.
+func (c *contract) checkErrorShape(method string, errs []error) bool {
+	if errs != nil && len(errs) == 0 {
+		c.errorf("%s: returned empty, non-nil error slice", method)
+		return false
+	}
+	for _, err := range errs {
+		if err == nil {
+			c.errorf("%s: returned nil entry in error slice", method)
+			return false
+		}
+	}
+	return true
+}
+
+func scopeValues(scopes []product.Scope) []string {
+	values := []string{}
+	for _, s := range scopes {
+		values = append(values, s.Value())
+	}
+	return values
+}
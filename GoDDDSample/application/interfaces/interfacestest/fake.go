@@ -0,0 +1,153 @@
+package interfacestest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+const (
+	GetProductIds  = "GetProductIds"
+	GetProductById = "GetProductById"
+)
+
+// Fault makes matching calls fail with Err. An empty Method or Id matches any
+// method or id, and Times limits how many calls fail, with zero meaning every
+// call.
+type Fault struct {
+	Method string
+	Id     string
+	Err    error
+	Times  int
+}
+
+type faults []*Fault
+
+// match returns the error of the first fault matching a call, if any, and
+// removes the fault once it has failed Times calls. Callers hold the fake's
+// lock.
+func (fs *faults) match(method, id string) error {
+	for i, fault := range *fs {
+		if (fault.Method == "" || fault.Method == method) && (fault.Id == "" || fault.Id == id) {
+			if fault.Times > 0 {
+				fault.Times--
+				if fault.Times == 0 {
+					*fs = append((*fs)[:i], (*fs)[i+1:]...)
+				}
+			}
+			return fault.Err
+		}
+	}
+	return nil
+}
+
+// Fake is an in-memory ProductInformation for application layer tests. It's
+// safe for concurrent use.
+type Fake struct {
+	mu       sync.Mutex
+	ids      []product.ExternalProductId
+	products map[string]product.Product
+	faults   faults
+	latency  time.Duration
+	calls    map[string]int
+}
+
+func NewFake(products ...product.Product) *Fake {
+	f := &Fake{
+		products: map[string]product.Product{},
+		calls:    map[string]int{}}
+	for _, p := range products {
+		f.Add(p)
+	}
+	return f
+}
+
+func (f *Fake) Add(p product.Product) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := p.ExternalId().Value()
+	if _, ok := f.products[id]; !ok {
+		f.ids = append(f.ids, p.ExternalId())
+	}
+	f.products[id] = p
+}
+
+func (f *Fake) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// SetLatency delays every call by d, or until the context is done, e.g., to
+// exercise timeouts.
+func (f *Fake) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+func (f *Fake) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *Fake) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	if err := f.enter(ctx, GetProductIds, ""); err != nil {
+		return nil, []error{err}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]product.ExternalProductId, len(f.ids))
+	copy(ids, f.ids)
+	return ids, nil
+}
+
+func (f *Fake) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	if err := f.enter(ctx, GetProductById, id.Value()); err != nil {
+		return product.Product{}, []error{err}
+	}
+
+	f.mu.Lock()
+	p, ok := f.products[id.Value()]
+	f.mu.Unlock()
+	if !ok {
+		return product.Product{}, []error{fmt.Errorf("%w: %s", interfaces.ErrProductNotFound, id.Value())}
+	}
+
+	// Only hand out the scopes asked for, like the real service.
+	granted := []product.Scope{}
+	for _, s := range p.Scopes() {
+		for _, r := range scopes {
+			if s.Equals(r) {
+				granted = append(granted, s)
+				break
+			}
+		}
+	}
+	return product.NewProduct(p.ExternalId(), granted)
+}
+
+func (f *Fake) enter(ctx context.Context, method, id string) error {
+	f.mu.Lock()
+	f.calls[method]++
+	latency := f.latency
+	err := f.faults.match(method, id)
+	f.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+var _ interfaces.ProductInformation = (*Fake)(nil)
@@ -0,0 +1,90 @@
+package interfacestest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/m/application/interfaces/interfacestest"
+	"example.com/m/domain/product"
+)
+
+func TestFake(t *testing.T) {
+	foo, _ := product.NewScope("foo")
+	known, _ := product.NewExternalProductId("42")
+	unknown, _ := product.NewExternalProductId("7")
+	p, _ := product.NewProduct(known, []product.Scope{foo})
+
+	fixture := interfacestest.Fixture{Known: []product.ExternalProductId{known}, Unknown: unknown, Scopes: []product.Scope{foo}}
+	if err := interfacestest.TestProductInformation(interfacestest.NewFake(p), fixture); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeFaults(t *testing.T) {
+	known, _ := product.NewExternalProductId("42")
+	other, _ := product.NewExternalProductId("43")
+	p, _ := product.NewProduct(known, nil)
+	q, _ := product.NewProduct(other, nil)
+	errOnce, errTwice, errAlways := errors.New("once"), errors.New("twice"), errors.New("always")
+
+	f := interfacestest.NewFake(p, q)
+	f.Inject(interfacestest.Fault{Method: interfacestest.GetProductById, Id: "42", Err: errTwice, Times: 2})
+	f.Inject(interfacestest.Fault{Method: interfacestest.GetProductIds, Err: errOnce, Times: 1})
+	f.Inject(interfacestest.Fault{Id: "43", Err: errAlways})
+
+	getById := func(id product.ExternalProductId) error {
+		if _, errs := f.GetProductById(context.Background(), id, nil); len(errs) > 0 {
+			return errs[0]
+		}
+		return nil
+	}
+	getIds := func() error {
+		if _, errs := f.GetProductIds(context.Background()); len(errs) > 0 {
+			return errs[0]
+		}
+		return nil
+	}
+	steps := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"ids fails once", getIds, errOnce},
+		{"ids fault removed", getIds, nil},
+		{"42 fails", func() error { return getById(known) }, errTwice},
+		{"43 fails", func() error { return getById(other) }, errAlways},
+		{"42 fails twice", func() error { return getById(known) }, errTwice},
+		{"42 fault removed", func() error { return getById(known) }, nil},
+		{"43 fails always", func() error { return getById(other) }, errAlways},
+	}
+	for _, step := range steps {
+		if err := step.call(); err != step.want {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+	}
+	if f.Calls(interfacestest.GetProductIds) != 2 || f.Calls(interfacestest.GetProductById) != 5 {
+		t.Fatalf("got %d and %d calls, want 2 and 5", f.Calls(interfacestest.GetProductIds), f.Calls(interfacestest.GetProductById))
+	}
+}
+
+func TestFakeLatency(t *testing.T) {
+	f := interfacestest.NewFake()
+	f.SetLatency(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, errs := f.GetProductIds(ctx); len(errs) != 1 || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", errs)
+	}
+
+	f.SetLatency(10 * time.Millisecond)
+	start := time.Now()
+	if _, errs := f.GetProductIds(context.Background()); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("returned after %s, want at least 10ms", elapsed)
+	}
+}
@@ -0,0 +1,140 @@
+package interfacestest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+const (
+	GetProducts       = "GetProducts"
+	GetProduct        = "GetProduct"
+	SaveProduct       = "SaveProduct"
+	GetProductHistory = "GetProductHistory"
+)
+
+// Repository is an in-memory ProductRepository for application layer tests.
+// Products are listed in the order they were first saved. It's safe for
+// concurrent use.
+type Repository struct {
+	mu       sync.Mutex
+	ids      []product.ExternalProductId
+	products map[string]product.Product
+	history  map[string][]product.Event
+	faults   faults
+}
+
+// NewRepository returns a repository holding products, without recording
+// their events.
+func NewRepository(products ...product.Product) *Repository {
+	r := &Repository{
+		products: map[string]product.Product{},
+		history:  map[string][]product.Event{}}
+	for _, p := range products {
+		r.put(p)
+	}
+	return r
+}
+
+func (r *Repository) Inject(fault Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, &fault)
+}
+
+// LastEvent returns the type of the most recent event in the history of the
+// product with id, or "" if there is none.
+func (r *Repository) LastEvent(id string) product.EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.history[id]
+	if len(h) == 0 {
+		return ""
+	}
+	return h[len(h)-1].Type
+}
+
+func (r *Repository) GetProducts(ctx context.Context) ([]product.Product, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.faults.match(GetProducts, ""); err != nil {
+		return nil, []error{err}
+	}
+	ps := make([]product.Product, 0, len(r.ids))
+	for _, id := range r.ids {
+		ps = append(ps, r.products[id.Value()])
+	}
+	return ps, nil
+}
+
+func (r *Repository) GetProduct(ctx context.Context, id product.ExternalProductId) (product.Product, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.faults.match(GetProduct, id.Value()); err != nil {
+		return product.Product{}, []error{err}
+	}
+	p, ok := r.products[id.Value()]
+	if !ok {
+		return product.Product{}, []error{fmt.Errorf("%w: %s", interfaces.ErrProductNotFound, id.Value())}
+	}
+	return p, nil
+}
+
+func (r *Repository) SaveProduct(ctx context.Context, p product.Product) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := p.ExternalId().Value()
+	if err := r.faults.match(SaveProduct, id); err != nil {
+		return []error{err}
+	}
+	r.history[id] = append(r.history[id], p.Events()...)
+	p.ClearEvents()
+	r.put(p)
+	return nil
+}
+
+func (r *Repository) GetProductHistory(ctx context.Context, id product.ExternalProductId) ([]product.Event, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.faults.match(GetProductHistory, id.Value()); err != nil {
+		return nil, []error{err}
+	}
+	return append([]product.Event{}, r.history[id.Value()]...), nil
+}
+
+func (r *Repository) put(p product.Product) {
+	id := p.ExternalId().Value()
+	if _, ok := r.products[id]; !ok {
+		r.ids = append(r.ids, p.ExternalId())
+	}
+	r.products[id] = p
+}
+
+// NewProduct returns the product with id and scopes, failing tb if they
+// aren't valid.
+func NewProduct(tb testing.TB, id string, scopes ...string) product.Product {
+	tb.Helper()
+	externalId, err := product.NewExternalProductId(id)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	values := []product.Scope{}
+	for _, s := range scopes {
+		scope, err := product.NewScope(s)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		values = append(values, scope)
+	}
+	p, errs := product.NewProduct(externalId, values)
+	if len(errs) > 0 {
+		tb.Fatal(errs)
+	}
+	return p
+}
+
+var _ interfaces.ProductRepository = (*Repository)(nil)
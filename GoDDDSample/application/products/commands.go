@@ -0,0 +1,272 @@
+package products
+
+import (
+	"context"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+type CreateProductCommand struct {
+	Id     string
+	Scopes []string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (c CreateProductCommand) Run(ctx context.Context) (_ ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "CreateProductCommand")
+	defer func() { done(errs) }()
+
+	allowedScopes, err := c.Policy.Authorize(c.Principal, authorization.WriteProduct, c.Scopes)
+	if err != nil {
+		return ProductDto{}, err
+	}
+
+	errors := []error{}
+	externalId := application.CreateExternalProductId(c.Id, &errors)
+	scopes := application.CreateScopes(allowedScopes, &errors)
+	if len(errors) > 0 {
+		return ProductDto{}, errors
+	}
+
+	if _, err := c.ProductRepository.GetProduct(ctx, externalId); err == nil {
+		return ProductDto{}, []error{application.ValidationError{Field: "id", Err: ErrProductExists}}
+	} else if !isNotFound(err) {
+		return ProductDto{}, err
+	}
+
+	p, err := product.CreateProduct(externalId, scopes)
+	if err != nil {
+		return ProductDto{}, err
+	}
+	if err := c.ProductRepository.SaveProduct(ctx, p); err != nil {
+		return ProductDto{}, err
+	}
+	return MapProduct(p), nil
+}
+
+type UpdateProductScopesCommand struct {
+	Id     string
+	Scopes []string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (c UpdateProductScopesCommand) Run(ctx context.Context) (_ ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "UpdateProductScopesCommand")
+	defer func() { done(errs) }()
+
+	allowedScopes, err := c.Policy.Authorize(c.Principal, authorization.WriteProduct, c.Scopes)
+	if err != nil {
+		return ProductDto{}, err
+	}
+
+	errors := []error{}
+	externalId := application.CreateExternalProductId(c.Id, &errors)
+	scopes := application.CreateScopes(allowedScopes, &errors)
+	if len(errors) > 0 {
+		return ProductDto{}, errors
+	}
+
+	p, err := c.ProductRepository.GetProduct(ctx, externalId)
+	if err != nil {
+		return ProductDto{}, err
+	}
+	if p.UpdateScopes(scopes) {
+		if err := c.ProductRepository.SaveProduct(ctx, p); err != nil {
+			return ProductDto{}, err
+		}
+	}
+	return MapProduct(p), nil
+}
+
+type SyncResultDto struct {
+	Created   []ProductDto
+	Updated   []ProductDto
+	Unchanged int
+}
+
+// SyncProductsCommand copies every product from ProductInformation into
+// ProductRepository, requesting Scopes for each.
+type SyncProductsCommand struct {
+	Scopes []string
+
+	Principal          authorization.Principal
+	Policy             authorization.Policy
+	ProductInformation interfaces.ProductInformation
+	ProductRepository  interfaces.ProductRepository
+}
+
+func (c SyncProductsCommand) Run(ctx context.Context) (_ SyncResultDto, errs []error) {
+	ctx, done := application.Observe(ctx, "SyncProductsCommand")
+	defer func() { done(errs) }()
+
+	allowedScopes, err := c.Policy.Authorize(c.Principal, authorization.WriteProduct, c.Scopes)
+	if err != nil {
+		return SyncResultDto{}, err
+	}
+
+	errors := []error{}
+	scopes := application.CreateScopes(allowedScopes, &errors)
+	if len(errors) > 0 {
+		return SyncResultDto{}, errors
+	}
+
+	ids, err := c.ProductInformation.GetProductIds(ctx)
+	if err != nil {
+		return SyncResultDto{}, application.Upstream(err)
+	}
+
+	result := SyncResultDto{Created: []ProductDto{}, Updated: []ProductDto{}}
+	for _, id := range ids {
+		upstream, err := c.ProductInformation.GetProductById(ctx, id, scopes)
+		if err != nil {
+			return result, application.Upstream(err)
+		}
+
+		local, err := c.ProductRepository.GetProduct(ctx, id)
+		switch {
+		case err == nil:
+			if !local.UpdateScopes(upstream.Scopes()) {
+				result.Unchanged++
+				continue
+			}
+			result.Updated = append(result.Updated, MapProduct(local))
+		case isNotFound(err):
+			if local, err = product.CreateProduct(id, upstream.Scopes()); err != nil {
+				return result, err
+			}
+			result.Created = append(result.Created, MapProduct(local))
+		default:
+			return result, err
+		}
+
+		if err := c.ProductRepository.SaveProduct(ctx, local); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RequestPublicationCommand records the request to publish a product to a
+// channel and hands the resulting event to EventHandler, which is where the
+// publication process picks it up.
+type RequestPublicationCommand struct {
+	Id      string
+	Channel string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+	EventHandler      interfaces.EventHandler
+}
+
+func (c RequestPublicationCommand) Run(ctx context.Context) (_ ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "RequestPublicationCommand")
+	defer func() { done(errs) }()
+
+	if _, err := c.Policy.Authorize(c.Principal, authorization.WriteProduct, nil); err != nil {
+		return ProductDto{}, err
+	}
+
+	errors := []error{}
+	externalId := application.CreateExternalProductId(c.Id, &errors)
+	channel := application.CreateChannel(c.Channel, &errors)
+	if len(errors) > 0 {
+		return ProductDto{}, errors
+	}
+
+	p, err := c.ProductRepository.GetProduct(ctx, externalId)
+	if err != nil {
+		return ProductDto{}, err
+	}
+	if err := p.RequestPublication(channel); err != nil {
+		for _, e := range err {
+			errors = append(errors, application.ValidationError{Field: "product", Err: e})
+		}
+		return ProductDto{}, errors
+	}
+	if err := c.ProductRepository.SaveProduct(ctx, p); err != nil {
+		return ProductDto{}, err
+	}
+
+	for _, e := range p.Events() {
+		if err := c.EventHandler.Handle(ctx, e); err != nil {
+			return MapProduct(p), err
+		}
+	}
+	return MapProduct(p), nil
+}
+
+// CompletePublicationCommand is issued by the publication process once every
+// step has succeeded.
+type CompletePublicationCommand struct {
+	Id         string
+	Channel    string
+	Identifier string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (c CompletePublicationCommand) Run(ctx context.Context) (errs []error) {
+	ctx, done := application.Observe(ctx, "CompletePublicationCommand")
+	defer func() { done(errs) }()
+
+	return updatePublication(ctx, c.Principal, c.Policy, c.ProductRepository, c.Id, c.Channel,
+		func(p *product.Product, channel product.Channel) { p.MarkPublished(channel, c.Identifier) })
+}
+
+// FailPublicationCommand is issued by the publication process after it has
+// compensated for a failed step.
+type FailPublicationCommand struct {
+	Id      string
+	Channel string
+	Reason  string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (c FailPublicationCommand) Run(ctx context.Context) (errs []error) {
+	ctx, done := application.Observe(ctx, "FailPublicationCommand")
+	defer func() { done(errs) }()
+
+	return updatePublication(ctx, c.Principal, c.Policy, c.ProductRepository, c.Id, c.Channel,
+		func(p *product.Product, channel product.Channel) { p.MarkPublicationFailed(channel, c.Reason) })
+}
+
+func updatePublication(
+	ctx context.Context,
+	principal authorization.Principal,
+	policy authorization.Policy,
+	repository interfaces.ProductRepository,
+	id, channelValue string,
+	update func(*product.Product, product.Channel)) []error {
+	if _, err := policy.Authorize(principal, authorization.WriteProduct, nil); err != nil {
+		return err
+	}
+
+	errors := []error{}
+	externalId := application.CreateExternalProductId(id, &errors)
+	channel := application.CreateChannel(channelValue, &errors)
+	if len(errors) > 0 {
+		return errors
+	}
+
+	p, err := repository.GetProduct(ctx, externalId)
+	if err != nil {
+		return err
+	}
+	update(&p, channel)
+	return repository.SaveProduct(ctx, p)
+}
@@ -1,47 +1,95 @@
 package products
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"example.com/m/application"
+	"example.com/m/application/authorization"
 	"example.com/m/application/interfaces"
 	"example.com/m/domain/product"
 )
 
+var ErrProductExists = errors.New("product already exists")
+
 type ProductDto struct {
 	Id         int
 	ExternalId string
+	Scopes     []string
 }
 
 func MapProduct(p product.Product) ProductDto {
 	return ProductDto{
 		Id:         p.Id(),
-		ExternalId: p.ExternalId().Value()}
+		ExternalId: p.ExternalId().Value(),
+		Scopes:     mapScopes(p.Scopes())}
+}
+
+type ProductEventDto struct {
+	Type       string
+	ExternalId string
+	Scopes     []string
+	Channel    string
+	Detail     string
+	OccurredAt time.Time
+}
+
+func MapProductEvent(e product.Event) ProductEventDto {
+	return ProductEventDto{
+		Type:       string(e.Type),
+		ExternalId: e.ExternalId.Value(),
+		Scopes:     mapScopes(e.Scopes),
+		Channel:    e.Channel.Value(),
+		Detail:     e.Detail,
+		OccurredAt: e.OccurredAt}
+}
+
+func mapScopes(scopes []product.Scope) []string {
+	values := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		values = append(values, s.Value())
+	}
+	return values
+}
+
+func isNotFound(errs []error) bool {
+	for _, err := range errs {
+		if errors.Is(err, interfaces.ErrProductNotFound) {
+			return true
+		}
+	}
+	return false
 }
 
 type GetProductByIdQuery struct {
 	Id     string
 	Scopes []string
 
+	Principal          authorization.Principal
+	Policy             authorization.Policy
 	ProductInformation interfaces.ProductInformation
 }
 
-func (q GetProductByIdQuery) Run() (ProductDto, []error) {
-	errors := []error{}
+func (q GetProductByIdQuery) Run(ctx context.Context) (_ ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "GetProductByIdQuery")
+	defer func() { done(errs) }()
 
-	externalId := product.ExternalProductId{}
-	if v, err := product.NewExternalProductId(q.Id); err != nil {
-		errors = append(errors, err)
-	} else {
-		externalId = v
+	allowedScopes, err := q.Policy.Authorize(q.Principal, authorization.ReadProduct, q.Scopes)
+	if err != nil {
+		return ProductDto{}, err
 	}
 
-	scopes := application.CreateScopes(q.Scopes, errors)
+	errors := []error{}
+	externalId := application.CreateExternalProductId(q.Id, &errors)
+	scopes := application.CreateScopes(allowedScopes, &errors)
 
 	if len(errors) > 0 {
 		return ProductDto{}, errors
 	}
 
-	if p, err := q.ProductInformation.GetProductById(externalId, scopes); err != nil {
-		return ProductDto{}, err
+	if p, err := q.ProductInformation.GetProductById(ctx, externalId, scopes); err != nil {
+		return ProductDto{}, application.Upstream(err)
 	} else {
 		return MapProduct(p), nil
 	}
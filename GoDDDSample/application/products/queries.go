@@ -0,0 +1,139 @@
+package products
+
+import (
+	"context"
+	"strings"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+type ListProductsQuery struct {
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (q ListProductsQuery) Run(ctx context.Context) (_ []ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "ListProductsQuery")
+	defer func() { done(errs) }()
+
+	if _, err := q.Policy.Authorize(q.Principal, authorization.ReadProduct, nil); err != nil {
+		return nil, err
+	}
+
+	ps, err := q.ProductRepository.GetProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtos := make([]ProductDto, 0, len(ps))
+	for _, p := range ps {
+		dto := MapProduct(p)
+		dto.Scopes = q.Policy.Visible(q.Principal, dto.Scopes)
+		dtos = append(dtos, dto)
+	}
+	return dtos, nil
+}
+
+// SearchProductsQuery matches products whose external id contains Text and
+// which have Scope. Empty criteria match every product. If Scope isn't
+// granted and the policy trims scopes, nothing matches.
+type SearchProductsQuery struct {
+	Text  string
+	Scope string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (q SearchProductsQuery) Run(ctx context.Context) (_ []ProductDto, errs []error) {
+	ctx, done := application.Observe(ctx, "SearchProductsQuery")
+	defer func() { done(errs) }()
+
+	requested := []string{}
+	if q.Scope != "" {
+		requested = append(requested, q.Scope)
+	}
+	allowedScopes, err := q.Policy.Authorize(q.Principal, authorization.ReadProduct, requested)
+	if err != nil {
+		return nil, err
+	}
+	// Dropping the filter would widen the search to every product.
+	if len(allowedScopes) < len(requested) {
+		return []ProductDto{}, nil
+	}
+
+	errors := []error{}
+	scopes := application.CreateScopes(allowedScopes, &errors)
+	if len(errors) > 0 {
+		return nil, errors
+	}
+
+	ps, err := q.ProductRepository.GetProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtos := []ProductDto{}
+	for _, p := range ps {
+		if strings.Contains(p.ExternalId().Value(), q.Text) && hasScopes(p, scopes) {
+			dto := MapProduct(p)
+			dto.Scopes = q.Policy.Visible(q.Principal, dto.Scopes)
+			dtos = append(dtos, dto)
+		}
+	}
+	return dtos, nil
+}
+
+func hasScopes(p product.Product, scopes []product.Scope) bool {
+	for _, want := range scopes {
+		found := false
+		for _, s := range p.Scopes() {
+			if s.Equals(want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type GetProductHistoryQuery struct {
+	Id string
+
+	Principal         authorization.Principal
+	Policy            authorization.Policy
+	ProductRepository interfaces.ProductRepository
+}
+
+func (q GetProductHistoryQuery) Run(ctx context.Context) (_ []ProductEventDto, errs []error) {
+	ctx, done := application.Observe(ctx, "GetProductHistoryQuery")
+	defer func() { done(errs) }()
+
+	if _, err := q.Policy.Authorize(q.Principal, authorization.ReadProduct, nil); err != nil {
+		return nil, err
+	}
+
+	errors := []error{}
+	externalId := application.CreateExternalProductId(q.Id, &errors)
+	if len(errors) > 0 {
+		return nil, errors
+	}
+
+	events, err := q.ProductRepository.GetProductHistory(ctx, externalId)
+	if err != nil {
+		return nil, err
+	}
+	dtos := make([]ProductEventDto, 0, len(events))
+	for _, e := range events {
+		dto := MapProductEvent(e)
+		dto.Scopes = q.Policy.Visible(q.Principal, dto.Scopes)
+		dtos = append(dtos, dto)
+	}
+	return dtos, nil
+}
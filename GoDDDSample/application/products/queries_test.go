@@ -0,0 +1,79 @@
+package products
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces/interfacestest"
+)
+
+func reader(scopes ...string) authorization.Principal {
+	return authorization.Principal{
+		Subject:    "s",
+		Operations: []authorization.Operation{authorization.ReadProduct},
+		Scopes:     scopes}
+}
+
+func TestSearchProductsQuery(t *testing.T) {
+	products := interfacestest.NewRepository(interfacestest.NewProduct(t, "42", "foo"), interfacestest.NewProduct(t, "43"))
+
+	tests := []struct {
+		name      string
+		scope     string
+		principal authorization.Principal
+		mode      authorization.ScopeMode
+		want      []ProductDto
+		forbidden bool
+	}{
+		{"no filter", "", reader("foo"), authorization.TrimScopes, []ProductDto{{ExternalId: "42", Scopes: []string{"foo"}}, {ExternalId: "43", Scopes: []string{}}}, false},
+		{"granted filter", "foo", reader("foo"), authorization.TrimScopes, []ProductDto{{ExternalId: "42", Scopes: []string{"foo"}}}, false},
+		{"trimmed filter", "foo", reader(), authorization.TrimScopes, []ProductDto{}, false},
+		{"rejected filter", "foo", reader(), authorization.RejectScopes, nil, true},
+		{"ungranted scopes hidden", "", reader(), authorization.TrimScopes, []ProductDto{{ExternalId: "42", Scopes: []string{}}, {ExternalId: "43", Scopes: []string{}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := SearchProductsQuery{
+				Scope:             tt.scope,
+				Principal:         tt.principal,
+				Policy:            authorization.Policy{ScopeMode: tt.mode},
+				ProductRepository: products}.Run(context.Background())
+			if tt.forbidden {
+				if len(errs) == 0 {
+					t.Fatalf("got %v, want forbidden", got)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			for i := range got {
+				got[i].Id = 0
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListProductsQueryHidesUngrantedScopes(t *testing.T) {
+	products := interfacestest.NewRepository(interfacestest.NewProduct(t, "42", "foo"))
+	for _, tt := range []struct {
+		principal authorization.Principal
+		want      []string
+	}{
+		{reader("foo"), []string{"foo"}},
+		{reader(), []string{}},
+	} {
+		got, errs := ListProductsQuery{Principal: tt.principal, ProductRepository: products}.Run(context.Background())
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0].Scopes, tt.want) {
+			t.Fatalf("%v: got %+v, want scopes %v", tt.principal.Scopes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package publication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/application/products"
+	"example.com/m/domain/product"
+	"example.com/m/tracing"
+)
+
+// principal is who the process manager issues follow-up commands as.
+var principal = authorization.Principal{
+	Subject:    "publication-process",
+	Operations: []authorization.Operation{authorization.ReadProduct, authorization.WriteProduct}}
+
+type ProcessManager struct {
+	Store             Store
+	Identifiers       IdentifierRegistry
+	Notifier          Notifier
+	ProductRepository interfaces.ProductRepository
+
+	// StepTimeout bounds each step and Timeout the process as a whole.
+	StepTimeout time.Duration
+	Timeout     time.Duration
+}
+
+// Handle starts a publication process on PublicationRequested and ignores
+// other events. The process runs to completion, or failure, before Handle
+// returns. A failed publication isn't an error of Handle's, only failing to
+// persist the process is.
+func (m ProcessManager) Handle(ctx context.Context, e product.Event) []error {
+	if e.Type != product.PublicationRequested {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	p := Publication{
+		Id:            e.ExternalId.Value() + "-" + e.Channel.Value() + "-" + tracing.NewId(),
+		ExternalId:    e.ExternalId.Value(),
+		Channel:       e.Channel.Value(),
+		Step:          ValidateAttributes,
+		Status:        Running,
+		CorrelationId: tracing.CorrelationId(ctx),
+		StartedAt:     now,
+		Deadline:      now.Add(m.Timeout)}
+	if err := m.save(ctx, &p); err != nil {
+		return err
+	}
+	return m.run(ctx, p)
+}
+
+// CheckTimeouts fails and compensates running processes past their deadline,
+// e.g., those left behind by a crash. Steps don't run past the deadline, but
+// a process is given another StepTimeout to record the outcome of its last
+// step. Should it do so anyway, one of the two saves fails with ErrConflict.
+func (m ProcessManager) CheckTimeouts(ctx context.Context) []error {
+	ps, err := m.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	now := time.Now()
+	for _, p := range ps {
+		if p.Status == Running && now.After(p.Deadline.Add(m.StepTimeout)) {
+			errs = append(errs, m.fail(ctx, p, ErrTimeout)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (m ProcessManager) run(ctx context.Context, p Publication) []error {
+	for p.Status == Running {
+		if time.Now().After(p.Deadline) {
+			return m.fail(ctx, p, ErrTimeout)
+		}
+
+		deadline := time.Now().Add(m.StepTimeout)
+		if p.Deadline.Before(deadline) {
+			deadline = p.Deadline
+		}
+		stepCtx, cancel := context.WithDeadline(ctx, deadline)
+		stepCtx, span := tracing.Start(stepCtx, "application", "PublicationProcess."+string(p.Step))
+		next, err := m.step(stepCtx, &p)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: step %s: %s", ErrTimeout, p.Step, err)
+		}
+		if err != nil {
+			span.End(1)
+		} else {
+			span.End(0)
+		}
+		cancel()
+		if err != nil {
+			return m.fail(ctx, p, err)
+		}
+
+		slog.InfoContext(ctx, "publication step completed", "publication", p.Id, "step", p.Step)
+		p.Step = next
+		if next == Complete {
+			p.Status = Completed
+		}
+		if err := m.save(ctx, &p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step performs p's current step and returns the one to go to next.
+func (m ProcessManager) step(ctx context.Context, p *Publication) (Step, error) {
+	errs := []error{}
+	externalId := application.CreateExternalProductId(p.ExternalId, &errs)
+	channel := application.CreateChannel(p.Channel, &errs)
+	if len(errs) > 0 {
+		return "", joinErrors(errs)
+	}
+
+	switch p.Step {
+	case ValidateAttributes:
+		pr, err := m.ProductRepository.GetProduct(ctx, externalId)
+		if err != nil {
+			return "", joinErrors(err)
+		}
+		if err := pr.ValidateForPublication(); err != nil {
+			return "", joinErrors(err)
+		}
+		return ReserveIdentifier, nil
+
+	case ReserveIdentifier:
+		identifier, err := m.Identifiers.Reserve(ctx, p.Id, externalId, channel)
+		if err != nil {
+			return "", err
+		}
+		p.Identifier = identifier
+		return NotifyDownstream, nil
+
+	case NotifyDownstream:
+		pr, err := m.ProductRepository.GetProduct(ctx, externalId)
+		if err != nil {
+			return "", joinErrors(err)
+		}
+		if err := m.Notifier.Notify(ctx, Notice{
+			ExternalId: p.ExternalId,
+			Channel:    p.Channel,
+			Identifier: p.Identifier,
+			Scopes:     products.MapProduct(pr).Scopes}); err != nil {
+			return "", err
+		}
+		return CompletePublication, nil
+
+	case CompletePublication:
+		if err := (products.CompletePublicationCommand{
+			Id:                p.ExternalId,
+			Channel:           p.Channel,
+			Identifier:        p.Identifier,
+			Principal:         principal,
+			Policy:            authorization.Policy{},
+			ProductRepository: m.ProductRepository}).Run(ctx); err != nil {
+			return "", joinErrors(err)
+		}
+		return Complete, nil
+	}
+	return "", fmt.Errorf("unknown step %q", p.Step)
+}
+
+// fail runs compensating actions for the steps completed so far, in reverse
+// order, and records the failure on the product. Compensation runs on a
+// context of its own as ctx may be what timed out.
+func (m ProcessManager) fail(ctx context.Context, p Publication, cause error) []error {
+	slog.WarnContext(ctx, "publication failed", "publication", p.Id, "step", p.Step, "error", cause)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.StepTimeout)
+	defer cancel()
+
+	// Saving first claims the process, so it can't be compensated twice or
+	// complete while being compensated. It stays running until compensated,
+	// so should we crash in between, the timeout sweeper compensates it.
+	p.Error = cause.Error()
+	if err := m.save(ctx, &p); err != nil {
+		return err
+	}
+
+	p.Status = Failed
+	compensationErrs := []string{}
+	// Downstream may hold a notice even if notifying failed, e.g., timed out.
+	// Should retracting it fail, the identifier stays reserved, so it isn't
+	// handed out again while downstream may still use it.
+	if p.Step == NotifyDownstream || p.Step == CompletePublication {
+		if err := m.Notifier.Retract(ctx, Notice{
+			ExternalId: p.ExternalId,
+			Channel:    p.Channel,
+			Identifier: p.Identifier}); err != nil {
+			compensationErrs = append(compensationErrs, "retract notice: "+err.Error())
+		}
+	}
+	// Past validation, an identifier may be reserved even if p doesn't record
+	// it, e.g., when reserving timed out.
+	if p.Step != ValidateAttributes && len(compensationErrs) == 0 {
+		channel, err := product.NewChannel(p.Channel)
+		if err == nil {
+			err = m.Identifiers.Release(ctx, channel, p.Id)
+		}
+		if err != nil {
+			compensationErrs = append(compensationErrs, "release identifier: "+err.Error())
+		}
+	}
+	if err := (products.FailPublicationCommand{
+		Id:                p.ExternalId,
+		Channel:           p.Channel,
+		Reason:            p.Error,
+		Principal:         principal,
+		Policy:            authorization.Policy{},
+		ProductRepository: m.ProductRepository}).Run(ctx); err != nil {
+		compensationErrs = append(compensationErrs, "record failure: "+joinErrors(err).Error())
+	}
+	if len(compensationErrs) > 0 {
+		p.Status = CompensationFailed
+		p.Error += "; compensation: " + strings.Join(compensationErrs, "; ")
+		slog.ErrorContext(ctx, "publication compensation failed", "publication", p.Id, "error", p.Error)
+	}
+	return m.save(ctx, &p)
+}
+
+func (m ProcessManager) save(ctx context.Context, p *Publication) []error {
+	p.UpdatedAt = time.Now().UTC()
+	p.Version++
+	return m.Store.Save(ctx, *p)
+}
+
+type stepErrors []error
+
+func (s stepErrors) Error() string {
+	messages := make([]string, 0, len(s))
+	for _, err := range s {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (s stepErrors) Unwrap() []error { return s }
+
+func joinErrors(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return stepErrors(errs)
+}
+
+var _ interfaces.EventHandler = ProcessManager{}
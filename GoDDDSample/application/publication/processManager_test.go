@@ -0,0 +1,353 @@
+package publication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/m/application/interfaces/interfacestest"
+	"example.com/m/domain/product"
+)
+
+// store keeps processes in memory, with the same optimistic concurrency as
+// infrastructure.FilePublicationStore.
+type store struct {
+	mu sync.Mutex
+	ps map[string]Publication
+}
+
+func newStore() *store { return &store{ps: map[string]Publication{}} }
+
+func (s *store) Save(ctx context.Context, p Publication) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.Version != s.ps[p.Id].Version+1 {
+		return []error{ErrConflict}
+	}
+	s.ps[p.Id] = p
+	return nil
+}
+
+func (s *store) Get(ctx context.Context, id string) (Publication, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.ps[id]
+	if !ok {
+		return Publication{}, []error{fmt.Errorf("publication %q not found", id)}
+	}
+	return p, nil
+}
+
+func (s *store) List(ctx context.Context) ([]Publication, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Publication{}
+	for _, p := range s.ps {
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// registry reserves identifiers in memory. Reserve runs reserved, if set,
+// after reserving, and Release fails with releaseErr, if set.
+type registry struct {
+	mu         sync.Mutex
+	next       int
+	keys       map[string]string
+	reserved   func(ctx context.Context) error
+	releaseErr error
+}
+
+func newRegistry() *registry { return &registry{keys: map[string]string{}} }
+
+func (r *registry) Reserve(ctx context.Context, key string, id product.ExternalProductId, channel product.Channel) (string, error) {
+	r.mu.Lock()
+	identifier, ok := r.keys[key]
+	if !ok {
+		r.next++
+		identifier = fmt.Sprintf("%s-%06d", channel.Value(), r.next)
+		r.keys[key] = identifier
+	}
+	r.mu.Unlock()
+	if r.reserved != nil {
+		if err := r.reserved(ctx); err != nil {
+			return "", err
+		}
+	}
+	return identifier, nil
+}
+
+func (r *registry) Release(ctx context.Context, channel product.Channel, key string) error {
+	if r.releaseErr != nil {
+		return r.releaseErr
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, key)
+	return nil
+}
+
+func (r *registry) reservations() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.keys)
+}
+
+// notifier runs notify, if set, for notices and counts retractions, which
+// fail with retractErr, if set.
+type notifier struct {
+	notify     func(ctx context.Context, n Notice) error
+	retractErr error
+	mu         sync.Mutex
+	retracted  int
+}
+
+func (n *notifier) Notify(ctx context.Context, notice Notice) error {
+	if n.notify == nil {
+		return nil
+	}
+	return n.notify(ctx, notice)
+}
+
+func (n *notifier) Retract(ctx context.Context, notice Notice) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.retracted++
+	return n.retractErr
+}
+
+func (n *notifier) retractions() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.retracted
+}
+
+func requested(t *testing.T, p product.Product) product.Event {
+	t.Helper()
+	channel, err := product.NewChannel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return product.Event{Type: product.PublicationRequested, ExternalId: p.ExternalId(), Channel: channel}
+}
+
+func blockUntilDone(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestProcessManagerHandle(t *testing.T) {
+	tests := []struct {
+		name         string
+		scopes       []string
+		stepTimeout  time.Duration
+		timeout      time.Duration
+		reserved     func(context.Context) error
+		notify       func(context.Context, Notice) error
+		retractErr   error
+		releaseErr   error
+		fault        *interfacestest.Fault // Of the product repository.
+		status       Status
+		err          error // Wrapped in Publication.Error, if not nil.
+		event        product.EventType
+		reservations int
+		retractions  int
+	}{
+		{
+			name:         "completes",
+			scopes:       []string{"foo"},
+			status:       Completed,
+			event:        product.Published,
+			reservations: 1,
+		},
+		{
+			name:   "invalid product fails without reserving",
+			status: Failed,
+			event:  product.PublicationFailed,
+		},
+		{
+			name:        "step failure compensates",
+			scopes:      []string{"foo"},
+			notify:      func(context.Context, Notice) error { return errors.New("downstream unavailable") },
+			status:      Failed,
+			event:       product.PublicationFailed,
+			retractions: 1,
+		},
+		{
+			name:        "step timeout",
+			scopes:      []string{"foo"},
+			stepTimeout: 10 * time.Millisecond,
+			notify:      func(ctx context.Context, _ Notice) error { return blockUntilDone(ctx) },
+			status:      Failed,
+			err:         ErrTimeout,
+			event:       product.PublicationFailed,
+			retractions: 1,
+		},
+		{
+			name:        "process timeout cuts a step short",
+			scopes:      []string{"foo"},
+			timeout:     10 * time.Millisecond,
+			notify:      func(ctx context.Context, _ Notice) error { return blockUntilDone(ctx) },
+			status:      Failed,
+			err:         ErrTimeout,
+			event:       product.PublicationFailed,
+			retractions: 1,
+		},
+		{
+			name:        "completion failure retracts notice",
+			scopes:      []string{"foo"},
+			fault:       &interfacestest.Fault{Method: interfacestest.SaveProduct, Err: errors.New("disk full"), Times: 1},
+			status:      Failed,
+			event:       product.PublicationFailed,
+			retractions: 1,
+		},
+		{
+			name:         "failed retraction keeps reservation",
+			scopes:       []string{"foo"},
+			fault:        &interfacestest.Fault{Method: interfacestest.SaveProduct, Err: errors.New("disk full"), Times: 1},
+			retractErr:   errors.New("downstream unavailable"),
+			status:       CompensationFailed,
+			event:        product.PublicationFailed,
+			reservations: 1,
+			retractions:  1,
+		},
+		{
+			name:        "unrecorded reservation is released",
+			scopes:      []string{"foo"},
+			stepTimeout: 10 * time.Millisecond,
+			reserved:    blockUntilDone,
+			status:      Failed,
+			err:         ErrTimeout,
+			event:       product.PublicationFailed,
+		},
+		{
+			name:         "compensation failure",
+			scopes:       []string{"foo"},
+			notify:       func(context.Context, Notice) error { return errors.New("downstream unavailable") },
+			releaseErr:   errors.New("registry unavailable"),
+			status:       CompensationFailed,
+			event:        product.PublicationFailed,
+			reservations: 1,
+			retractions:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := interfacestest.NewProduct(t, "42", tt.scopes...)
+			processes := newStore()
+			identifiers := newRegistry()
+			identifiers.reserved = tt.reserved
+			identifiers.releaseErr = tt.releaseErr
+			products := interfacestest.NewRepository(p)
+			if tt.fault != nil {
+				products.Inject(*tt.fault)
+			}
+			notifications := &notifier{notify: tt.notify, retractErr: tt.retractErr}
+			m := ProcessManager{
+				Store:             processes,
+				Identifiers:       identifiers,
+				Notifier:          notifications,
+				ProductRepository: products,
+				StepTimeout:       time.Second,
+				Timeout:           time.Minute}
+			if tt.stepTimeout > 0 {
+				m.StepTimeout = tt.stepTimeout
+			}
+			if tt.timeout > 0 {
+				m.Timeout = tt.timeout
+			}
+
+			start := time.Now()
+			if errs := m.Handle(context.Background(), requested(t, p)); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			// Well within the default step timeout, so no step overran.
+			if elapsed := time.Since(start); elapsed > m.StepTimeout/2 && tt.stepTimeout == 0 {
+				t.Errorf("took %s", elapsed)
+			}
+
+			ps, _ := processes.List(context.Background())
+			if len(ps) != 1 {
+				t.Fatalf("got %d processes, want 1", len(ps))
+			}
+			if ps[0].Status != tt.status {
+				t.Errorf("got status %s (%s), want %s", ps[0].Status, ps[0].Error, tt.status)
+			}
+			if tt.err != nil && !strings.Contains(ps[0].Error, tt.err.Error()) {
+				t.Errorf("got error %q, want %q", ps[0].Error, tt.err)
+			}
+			if got := products.LastEvent("42"); got != tt.event {
+				t.Errorf("got last event %s, want %s", got, tt.event)
+			}
+			if got := identifiers.reservations(); got != tt.reservations {
+				t.Errorf("got %d reservations, want %d", got, tt.reservations)
+			}
+			if got := notifications.retractions(); got != tt.retractions {
+				t.Errorf("got %d retractions, want %d", got, tt.retractions)
+			}
+		})
+	}
+}
+
+func TestProcessManagerCheckTimeouts(t *testing.T) {
+	p := interfacestest.NewProduct(t, "42", "foo")
+	processes := newStore()
+	identifiers := newRegistry()
+	m := ProcessManager{
+		Store:             processes,
+		Identifiers:       identifiers,
+		Notifier:          &notifier{},
+		ProductRepository: interfacestest.NewRepository(p),
+		StepTimeout:       time.Minute,
+		Timeout:           time.Minute}
+
+	externalId := p.ExternalId()
+	channel := requested(t, p).Channel
+	now := time.Now().UTC()
+	running := func(id string, deadline time.Time) Publication {
+		return Publication{Id: id, ExternalId: "42", Channel: "web", Step: NotifyDownstream, Status: Running, Deadline: deadline, Version: 1}
+	}
+	for _, process := range []Publication{
+		running("abandoned", now.Add(-2*time.Minute)),
+		// Past its deadline, but its last step may still be recording.
+		running("overrunning", now.Add(-time.Second)),
+		running("on time", now.Add(time.Minute)),
+	} {
+		if errs := processes.Save(context.Background(), process); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if _, err := identifiers.Reserve(context.Background(), process.Id, externalId, channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if errs := m.CheckTimeouts(context.Background()); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	for id, want := range map[string]Status{"abandoned": Failed, "overrunning": Running, "on time": Running} {
+		got, _ := processes.Get(context.Background(), id)
+		if got.Status != want {
+			t.Errorf("%s: got status %s, want %s", id, got.Status, want)
+		}
+	}
+	if got := identifiers.reservations(); got != 2 {
+		t.Errorf("got %d reservations, want 2", got)
+	}
+
+	// The overrunning process recording its step after the sweeper has
+	// claimed it fails rather than overwriting the failure.
+	claimed, _ := processes.Get(context.Background(), "overrunning")
+	m.StepTimeout = time.Millisecond
+	if errs := m.CheckTimeouts(context.Background()); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	claimed.Status = Completed
+	if errs := m.save(context.Background(), &claimed); len(errs) != 1 || !errors.Is(errs[0], ErrConflict) {
+		t.Fatalf("got %v, want %v", errs, ErrConflict)
+	}
+}
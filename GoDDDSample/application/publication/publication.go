@@ -0,0 +1,94 @@
+// Package publication runs the process of publishing a product to a channel:
+// validating the product's attributes, reserving an identifier for it in the
+// channel, notifying downstream systems, and recording the publication on the
+// product. The process manager reacts to
+// PublicationRequested events, persists its progress after every step, and
+// compensates for completed steps when a later one fails or times out.
+package publication
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"example.com/m/domain/product"
+)
+
+var (
+	ErrTimeout  = errors.New("publication timed out")
+	ErrConflict = errors.New("publication changed concurrently")
+)
+
+type Step string
+
+const (
+	ValidateAttributes  Step = "validate-attributes"
+	ReserveIdentifier   Step = "reserve-identifier"
+	NotifyDownstream    Step = "notify-downstream"
+	CompletePublication Step = "complete-publication"
+	Complete            Step = "complete"
+)
+
+type Status string
+
+const (
+	Running   Status = "running"
+	Completed Status = "completed"
+	Failed    Status = "failed"
+	// CompensationFailed means the process failed and undoing its completed
+	// steps failed too, so it needs manual attention.
+	CompensationFailed Status = "compensation-failed"
+)
+
+// Publication is the persisted state of one publication process.
+type Publication struct {
+	Id            string
+	ExternalId    string
+	Channel       string
+	Step          Step
+	Status        Status
+	Identifier    string
+	Error         string
+	CorrelationId string
+	StartedAt     time.Time
+	UpdatedAt     time.Time
+	Deadline      time.Time
+	// Version is incremented by every save.
+	Version int
+}
+
+// Store persists publication processes. Save inserts or replaces by Id, but
+// only if p.Version is one more than the stored process' version, or 1 for a
+// new one. Otherwise it fails with ErrConflict, so two process managers, e.g.,
+// productctl's and productd's timeout sweeper, can't overwrite each other.
+type Store interface {
+	Save(ctx context.Context, p Publication) []error
+	Get(ctx context.Context, id string) (Publication, []error)
+	List(ctx context.Context) ([]Publication, []error)
+}
+
+// IdentifierRegistry hands out channel specific identifiers for products.
+// Reservations are made and released by key, the publication process id, and
+// reserving again with the same key returns the same identifier. That way, a
+// reservation isn't lost if the process stops before recording it.
+type IdentifierRegistry interface {
+	Reserve(ctx context.Context, key string, id product.ExternalProductId, channel product.Channel) (string, error)
+	// Release does nothing if nothing is reserved with key.
+	Release(ctx context.Context, channel product.Channel, key string) error
+}
+
+type Notice struct {
+	ExternalId string   `json:"externalId"`
+	Channel    string   `json:"channel"`
+	Identifier string   `json:"identifier"`
+	Scopes     []string `json:"scopes"`
+}
+
+// Notifier tells downstream systems about a product ready in a channel, and
+// retracts the notice should the publication fail afterwards. Retract must
+// succeed for a notice downstream never received, as a failed or timed out
+// Notify may or may not have been delivered.
+type Notifier interface {
+	Notify(ctx context.Context, n Notice) error
+	Retract(ctx context.Context, n Notice) error
+}
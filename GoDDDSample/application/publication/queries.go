@@ -0,0 +1,39 @@
+package publication
+
+import (
+	"context"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+)
+
+// GetPublicationsQuery lists publication processes, optionally only those of
+// the product with ExternalId.
+type GetPublicationsQuery struct {
+	ExternalId string
+
+	Principal authorization.Principal
+	Policy    authorization.Policy
+	Store     Store
+}
+
+func (q GetPublicationsQuery) Run(ctx context.Context) (_ []Publication, errs []error) {
+	ctx, done := application.Observe(ctx, "GetPublicationsQuery")
+	defer func() { done(errs) }()
+
+	if _, err := q.Policy.Authorize(q.Principal, authorization.ReadProduct, nil); err != nil {
+		return nil, err
+	}
+
+	ps, err := q.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matching := []Publication{}
+	for _, p := range ps {
+		if q.ExternalId == "" || p.ExternalId == q.ExternalId {
+			matching = append(matching, p)
+		}
+	}
+	return matching, nil
+}
@@ -1,12 +1,97 @@
 package application
 
-import "example.com/m/domain/product"
+import (
+	"errors"
 
-func CreateScopes(scopeStrings []string, errors []error) []product.Scope {
-	scopes := make([]product.Scope, len(scopeStrings))
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+// ValidationError marks an error caused by invalid input, as opposed to a
+// failure further down the stack.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e ValidationError) Error() string { return e.Field + ": " + e.Err.Error() }
+func (e ValidationError) Unwrap() error { return e.Err }
+
+// UpstreamError marks an error reported by an external system, such as
+// ProductInformation.
+type UpstreamError struct {
+	Err error
+}
+
+func (e UpstreamError) Error() string { return "upstream: " + e.Err.Error() }
+func (e UpstreamError) Unwrap() error { return e.Err }
+
+func Upstream(errors []error) []error {
+	wrapped := make([]error, 0, len(errors))
+	for _, err := range errors {
+		wrapped = append(wrapped, UpstreamError{Err: err})
+	}
+	return wrapped
+}
+
+type ErrorKind int
+
+const (
+	InternalError ErrorKind = iota
+	InvalidInput
+	Forbidden
+	NotFound
+	UpstreamFailure
+)
+
+// Classify picks the most specific kind among errs, preferring those the
+// caller can act on. Entry points map it to exit codes or status codes.
+func Classify(errs []error) ErrorKind {
+	has := func(match func(error) bool) bool {
+		for _, err := range errs {
+			if match(err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has(func(err error) bool { var v ValidationError; return errors.As(err, &v) }):
+		return InvalidInput
+	case has(func(err error) bool { return errors.Is(err, authorization.ErrForbidden) }):
+		return Forbidden
+	case has(func(err error) bool { return errors.Is(err, interfaces.ErrProductNotFound) }):
+		return NotFound
+	case has(func(err error) bool { var u UpstreamError; return errors.As(err, &u) }):
+		return UpstreamFailure
+	default:
+		return InternalError
+	}
+}
+
+func CreateExternalProductId(id string, errors *[]error) product.ExternalProductId {
+	v, err := product.NewExternalProductId(id)
+	if err != nil {
+		*errors = append(*errors, ValidationError{Field: "id", Err: err})
+	}
+	return v
+}
+
+func CreateChannel(channel string, errors *[]error) product.Channel {
+	v, err := product.NewChannel(channel)
+	if err != nil {
+		*errors = append(*errors, ValidationError{Field: "channel", Err: err})
+	}
+	return v
+}
+
+func CreateScopes(scopeStrings []string, errors *[]error) []product.Scope {
+	scopes := make([]product.Scope, 0, len(scopeStrings))
 	for _, scope := range scopeStrings {
 		if v, err := product.NewScope(scope); err != nil {
-			errors = append(errors, err)
+			*errors = append(*errors, ValidationError{Field: "scopes", Err: err})
 		} else {
 			scopes = append(scopes, v)
 		}
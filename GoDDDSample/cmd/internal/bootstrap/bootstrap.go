@@ -0,0 +1,145 @@
+// Package bootstrap wires application dependencies from configuration for
+// the commands.
+package bootstrap
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/application/publication"
+	"example.com/m/config"
+	"example.com/m/infrastructure"
+	"example.com/m/tracing"
+)
+
+// Observability sets up the default slog logger, writing to w, and the span
+// exporter. The returned function closes the trace file.
+func Observability(c config.Config, w io.Writer) (func(), error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if c.Log.Format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	slog.SetDefault(slog.New(tracing.NewHandler(handler)))
+
+	if c.Log.TraceFile == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(c.Log.TraceFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	tracing.SetExporter(tracing.NewJSONLinesExporter(f))
+	return func() { f.Close() }, nil
+}
+
+// ProductInformation returns upstream behind the configured circuit breaker
+// and cache. The cache goes in front so hits don't count toward the breaker.
+func ProductInformation(c config.Config) interfaces.ProductInformation {
+	if c.Upstream.BaseURL == "" {
+		return infrastructure.StubProductInformation{}
+	}
+	var pi interfaces.ProductInformation = infrastructure.NewStiboDaaSClient(
+		c.Upstream.BaseURL,
+		c.Upstream.ClientId,
+		c.Upstream.ClientSecret.Reveal(),
+		c.Upstream.Timeout.Duration)
+	if c.Upstream.BreakerThreshold > 0 {
+		pi = infrastructure.NewResilientProductInformation("stibo_daas", pi, c.Upstream.BreakerThreshold, c.Upstream.BreakerCooldown.Duration)
+	}
+	if c.Upstream.CacheTTL.Duration > 0 {
+		pi = infrastructure.NewCachedProductInformation(pi, c.Upstream.CacheTTL.Duration, c.Upstream.CacheSize)
+	}
+	return pi
+}
+
+func ProductRepository(c config.Config) interfaces.ProductRepository {
+	return &infrastructure.FileProductRepository{Path: c.Store.Path}
+}
+
+func PublicationStore(c config.Config) publication.Store {
+	return &infrastructure.FilePublicationStore{Path: c.Publication.StorePath}
+}
+
+func ProcessManager(c config.Config, repository interfaces.ProductRepository) publication.ProcessManager {
+	var notifier publication.Notifier = infrastructure.LogNotifier{}
+	if c.Publication.WebhookURL != "" {
+		notifier = infrastructure.WebhookNotifier{
+			URL:        c.Publication.WebhookURL,
+			HTTPClient: &http.Client{Timeout: c.Publication.StepTimeout.Duration}}
+	}
+	return publication.ProcessManager{
+		Store:             PublicationStore(c),
+		Identifiers:       &infrastructure.FileIdentifierRegistry{Path: c.Publication.IdentifierPath},
+		Notifier:          notifier,
+		ProductRepository: repository,
+		StepTimeout:       c.Publication.StepTimeout.Duration,
+		Timeout:           c.Publication.Timeout.Duration}
+}
+
+func Policy(c config.Config) authorization.Policy {
+	if c.Auth.ScopeMode == "trim" {
+		return authorization.Policy{ScopeMode: authorization.TrimScopes}
+	}
+	return authorization.Policy{ScopeMode: authorization.RejectScopes}
+}
+
+// The development key is in the repository for all to see, so tokens signed
+// with it prove nothing. Only productctl trusts it, and only in dev mode.
+const (
+	devKeyId = "dev"
+	devKey   = "development-only-key"
+)
+
+func KeySet(c config.Config) infrastructure.StaticKeySet {
+	keys := infrastructure.StaticKeySet{}
+	for kid, key := range c.Auth.Keys {
+		keys[kid] = []byte(key.Reveal())
+	}
+	if c.Auth.Dev {
+		keys[devKeyId] = []byte(devKey)
+	}
+	return keys
+}
+
+// TrustsDevKey reports whether c is in dev mode or has the development key
+// configured by hand, which a server must refuse.
+func TrustsDevKey(c config.Config) bool {
+	if c.Auth.Dev {
+		return true
+	}
+	for _, key := range c.Auth.Keys {
+		if key.Reveal() == devKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal validates the configured bearer token. Without a token in dev
+// mode, it acts as a development user.
+func Principal(c config.Config) (authorization.Principal, error) {
+	keys := KeySet(c)
+
+	token := c.Auth.Token.Reveal()
+	if token == "" && c.Auth.Dev {
+		var err error
+		token, err = keys.Issue(devKeyId, authorization.Principal{
+			Subject:    "dev",
+			Operations: []authorization.Operation{authorization.ReadProduct, authorization.WriteProduct},
+			Scopes:     []string{"foo"}}, time.Now().Add(time.Hour))
+		if err != nil {
+			return authorization.Principal{}, err
+		}
+	}
+	return keys.Validate(token)
+}
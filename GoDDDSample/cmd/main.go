@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
 
 	"example.com/m/application/products"
-	"example.com/m/infrastructure"
+	"example.com/m/cmd/internal/bootstrap"
+	"example.com/m/config"
+	"example.com/m/tracing"
 )
 
 type Product struct {
@@ -19,12 +24,41 @@ func (p ProductJson) MarshallJson() ([]byte, error) {
 }
 
 func main() {
-	q := products.GetProductByIdQuery{Id: "123", Scopes: nil, ProductInformation: infrastructure.StiboDaaSClient{}}
-	p, err := q.Run()
+	flags := flag.NewFlagSet("main", flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print effective configuration and exit")
+	c, err := config.Load(flags, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		c.Print(os.Stdout)
+		return
+	}
+
+	closeTrace, err := bootstrap.Observability(c, os.Stderr)
+	if err != nil {
+		panic(err)
+	}
+	defer closeTrace()
+
+	principal, err := bootstrap.Principal(c)
 	if err != nil {
 		panic(err)
 	}
 
+	q := products.GetProductByIdQuery{
+		Id:                 c.Query.Id,
+		Scopes:             c.Query.Scopes,
+		Principal:          principal,
+		Policy:             bootstrap.Policy(c),
+		ProductInformation: bootstrap.ProductInformation(c)}
+	ctx := tracing.WithCorrelationId(context.Background(), tracing.NewId())
+	p, errs := q.Run(ctx)
+	if errs != nil {
+		panic(errs)
+	}
+
 	s, _ := json.MarshalIndent(p, "", "  ")
 	t := string(s)
 	fmt.Printf("%s\n", t)
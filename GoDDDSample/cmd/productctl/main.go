@@ -0,0 +1,451 @@
+// Command productctl queries and changes products through the application
+// layer.
+//
+//	productctl [-format table|json|csv] [-print-config] [config flags] <command> [arguments]
+//
+// Commands are get, list, search, create, update-scopes, sync, history,
+// publish and publications.
+// See package config for configuration flags and environment variables.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/application/products"
+	"example.com/m/application/publication"
+	"example.com/m/cmd/internal/bootstrap"
+	"example.com/m/config"
+	"example.com/m/tracing"
+)
+
+// Exit codes let scripts tell bad input apart from failures elsewhere.
+const (
+	exitOK         = 0
+	exitFailure    = 1
+	exitUsage      = 2
+	exitValidation = 3
+	exitForbidden  = 4
+	exitNotFound   = 5
+	exitUpstream   = 6
+)
+
+type app struct {
+	format     string
+	principal  authorization.Principal
+	policy     authorization.Policy
+	upstream   interfaces.ProductInformation
+	repository interfaces.ProductRepository
+	process    publication.ProcessManager
+	store      publication.Store
+	out        io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("productctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "table", "output format: table, json or csv")
+	printConfig := flags.Bool("print-config", false, "print effective configuration and exit")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: productctl [flags] get|list|search|create|update-scopes|sync|history|publish|publications [arguments]")
+		flags.PrintDefaults()
+	}
+	c, err := config.Load(flags, args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitUsage
+	} else if err != nil {
+		fmt.Fprintf(stderr, "productctl: %s\n", err)
+		return exitUsage
+	}
+	if *printConfig {
+		if err := c.Print(stdout); err != nil {
+			fmt.Fprintf(stderr, "productctl: %s\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		fmt.Fprintf(stderr, "productctl: unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	closeTrace, err := bootstrap.Observability(c, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "productctl: %s\n", err)
+		return exitFailure
+	}
+	defer closeTrace()
+
+	principal, err := bootstrap.Principal(c)
+	if err != nil {
+		fmt.Fprintf(stderr, "productctl: %s\n", err)
+		return exitForbidden
+	}
+
+	repository := bootstrap.ProductRepository(c)
+	a := app{
+		format:     *format,
+		principal:  principal,
+		policy:     bootstrap.Policy(c),
+		upstream:   bootstrap.ProductInformation(c),
+		repository: repository,
+		process:    bootstrap.ProcessManager(c, repository),
+		store:      bootstrap.PublicationStore(c),
+		out:        stdout}
+
+	commands := map[string]func(context.Context, []string) (int, []error){
+		"get":           a.get,
+		"list":          a.list,
+		"search":        a.search,
+		"create":        a.create,
+		"update-scopes": a.updateScopes,
+		"sync":          a.sync,
+		"history":       a.history,
+		"publish":       a.publish,
+		"publications":  a.publications,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "productctl: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage
+	}
+
+	// Each invocation is a request of its own as far as tracing goes.
+	ctx := tracing.WithCorrelationId(context.Background(), tracing.NewId())
+	ctx, span := tracing.Start(ctx, "cli", "productctl "+flags.Arg(0))
+	slog.DebugContext(ctx, "running command", "command", flags.Arg(0))
+	code, errs := command(ctx, flags.Args()[1:])
+	span.End(len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(stderr, "productctl: %s\n", err)
+	}
+	if code == exitOK && len(errs) > 0 {
+		code = exitCode(errs)
+	}
+	return code
+}
+
+func exitCode(errs []error) int {
+	switch application.Classify(errs) {
+	case application.InvalidInput:
+		return exitValidation
+	case application.Forbidden:
+		return exitForbidden
+	case application.NotFound:
+		return exitNotFound
+	case application.UpstreamFailure:
+		return exitUpstream
+	default:
+		return exitFailure
+	}
+}
+
+// parse parses a subcommand's flags and checks the number of positional
+// arguments. Unlike flag.Parse, flags may follow positional arguments, e.g.,
+// "create 42 -scopes foo".
+func parse(flags *flag.FlagSet, args []string, positional ...string) ([]string, bool) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: productctl %s [flags] %s\n", flags.Name(), strings.Join(positional, " "))
+		flags.PrintDefaults()
+	}
+	values := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, false
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		values = append(values, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(values) != len(positional) {
+		flags.Usage()
+		return nil, false
+	}
+	return values, true
+}
+
+func scopesFlag(flags *flag.FlagSet) *string {
+	return flags.String("scopes", "", "comma-separated scopes")
+}
+
+func splitScopes(s string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func (a app) get(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	scopes := scopesFlag(flags)
+	positional, ok := parse(flags, args, "id")
+	if !ok {
+		return exitUsage, nil
+	}
+
+	p, errs := products.GetProductByIdQuery{
+		Id:                 positional[0],
+		Scopes:             splitScopes(*scopes),
+		Principal:          a.principal,
+		Policy:             a.policy,
+		ProductInformation: a.upstream}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderProducts(p, []products.ProductDto{p})
+}
+
+func (a app) list(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	if _, ok := parse(flags, args); !ok {
+		return exitUsage, nil
+	}
+
+	ps, errs := products.ListProductsQuery{
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderProducts(ps, ps)
+}
+
+func (a app) search(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	text := flags.String("text", "", "text contained in the external id")
+	scope := flags.String("scope", "", "scope the product must have")
+	if _, ok := parse(flags, args); !ok {
+		return exitUsage, nil
+	}
+
+	ps, errs := products.SearchProductsQuery{
+		Text:              *text,
+		Scope:             *scope,
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderProducts(ps, ps)
+}
+
+func (a app) create(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	scopes := scopesFlag(flags)
+	positional, ok := parse(flags, args, "id")
+	if !ok {
+		return exitUsage, nil
+	}
+
+	p, errs := products.CreateProductCommand{
+		Id:                positional[0],
+		Scopes:            splitScopes(*scopes),
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderProducts(p, []products.ProductDto{p})
+}
+
+func (a app) updateScopes(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("update-scopes", flag.ContinueOnError)
+	scopes := scopesFlag(flags)
+	positional, ok := parse(flags, args, "id")
+	if !ok {
+		return exitUsage, nil
+	}
+
+	p, errs := products.UpdateProductScopesCommand{
+		Id:                positional[0],
+		Scopes:            splitScopes(*scopes),
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderProducts(p, []products.ProductDto{p})
+}
+
+func (a app) sync(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	scopes := scopesFlag(flags)
+	if _, ok := parse(flags, args); !ok {
+		return exitUsage, nil
+	}
+
+	result, errs := products.SyncProductsCommand{
+		Scopes:             splitScopes(*scopes),
+		Principal:          a.principal,
+		Policy:             a.policy,
+		ProductInformation: a.upstream,
+		ProductRepository:  a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+
+	rows := [][]string{}
+	for _, p := range result.Created {
+		rows = append(rows, []string{"created", p.ExternalId, strings.Join(p.Scopes, " ")})
+	}
+	for _, p := range result.Updated {
+		rows = append(rows, []string{"updated", p.ExternalId, strings.Join(p.Scopes, " ")})
+	}
+	return exitOK, a.render(result, []string{"CHANGE", "EXTERNAL ID", "SCOPES"}, rows)
+}
+
+func (a app) history(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	positional, ok := parse(flags, args, "id")
+	if !ok {
+		return exitUsage, nil
+	}
+
+	events, errs := products.GetProductHistoryQuery{
+		Id:                positional[0],
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+
+	rows := [][]string{}
+	for _, e := range events {
+		rows = append(rows, []string{e.OccurredAt.Format(time.RFC3339), e.Type, e.ExternalId, strings.Join(e.Scopes, " "), e.Channel, e.Detail})
+	}
+	return exitOK, a.render(events, []string{"OCCURRED AT", "TYPE", "EXTERNAL ID", "SCOPES", "CHANNEL", "DETAIL"}, rows)
+}
+
+func (a app) publish(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("publish", flag.ContinueOnError)
+	channel := flags.String("channel", "", "channel to publish to")
+	positional, ok := parse(flags, args, "id")
+	if !ok {
+		return exitUsage, nil
+	}
+
+	if _, errs := (products.RequestPublicationCommand{
+		Id:                positional[0],
+		Channel:           *channel,
+		Principal:         a.principal,
+		Policy:            a.policy,
+		ProductRepository: a.repository,
+		EventHandler:      a.process}).Run(ctx); errs != nil {
+		return exitOK, errs
+	}
+
+	// The process has run to its end by now, so show how it went.
+	ps, errs := publication.GetPublicationsQuery{
+		ExternalId: positional[0],
+		Principal:  a.principal,
+		Policy:     a.policy,
+		Store:      a.store}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	if len(ps) > 0 {
+		ps = ps[len(ps)-1:]
+	}
+	if err := a.renderPublications(ps); err != nil {
+		return exitOK, err
+	}
+	if len(ps) > 0 && ps[0].Status != publication.Completed {
+		return exitFailure, nil
+	}
+	return exitOK, nil
+}
+
+func (a app) publications(ctx context.Context, args []string) (int, []error) {
+	flags := flag.NewFlagSet("publications", flag.ContinueOnError)
+	id := flags.String("id", "", "only publications of product with external id")
+	if _, ok := parse(flags, args); !ok {
+		return exitUsage, nil
+	}
+
+	ps, errs := publication.GetPublicationsQuery{
+		ExternalId: *id,
+		Principal:  a.principal,
+		Policy:     a.policy,
+		Store:      a.store}.Run(ctx)
+	if errs != nil {
+		return exitOK, errs
+	}
+	return exitOK, a.renderPublications(ps)
+}
+
+func (a app) renderPublications(ps []publication.Publication) []error {
+	rows := [][]string{}
+	for _, p := range ps {
+		rows = append(rows, []string{p.Id, p.ExternalId, p.Channel, string(p.Status), string(p.Step), p.Identifier, p.Error})
+	}
+	return a.render(ps, []string{"ID", "EXTERNAL ID", "CHANNEL", "STATUS", "STEP", "IDENTIFIER", "ERROR"}, rows)
+}
+
+func (a app) renderProducts(v any, ps []products.ProductDto) []error {
+	rows := [][]string{}
+	for _, p := range ps {
+		rows = append(rows, []string{p.ExternalId, strings.Join(p.Scopes, " ")})
+	}
+	return a.render(v, []string{"EXTERNAL ID", "SCOPES"}, rows)
+}
+
+// render writes v as JSON, or header and rows as CSV or an aligned table.
+func (a app) render(v any, header []string, rows [][]string) []error {
+	var err error
+	switch a.format {
+	case "json":
+		e := json.NewEncoder(a.out)
+		e.SetIndent("", "  ")
+		err = e.Encode(v)
+	case "csv":
+		w := csv.NewWriter(a.out)
+		w.Write(header)
+		w.WriteAll(rows)
+		err = w.Error()
+	default:
+		w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		err = w.Flush()
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
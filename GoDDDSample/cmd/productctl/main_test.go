@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/m/application/authorization"
+	"example.com/m/infrastructure"
+)
+
+// productctl runs the command against files in dir and returns its exit code
+// and output.
+func productctl(t *testing.T, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr strings.Builder
+	args = append([]string{
+		"-store", filepath.Join(dir, "products.json"),
+		"-publication-store", filepath.Join(dir, "publications.json"),
+		"-publication-identifiers", filepath.Join(dir, "identifiers.json"),
+		"-log-level", "error"}, args...)
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// seed creates product 42 with scope foo and 43 without scopes.
+func seed(t *testing.T, dir string) {
+	t.Helper()
+	for _, args := range [][]string{{"create", "42", "-scopes", "foo"}, {"create", "43"}} {
+		if code, _, stderr := productctl(t, dir, append([]string{"-auth-dev"}, args...)...); code != exitOK {
+			t.Fatalf("%v: exit code %d: %s", args, code, stderr)
+		}
+	}
+}
+
+func failing(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+// reader is a token granting only reads, signed with key k=secret.
+func reader(t *testing.T) string {
+	t.Helper()
+	token, err := infrastructure.StaticKeySet{"k": []byte("secret")}.Issue("k", authorization.Principal{
+		Subject:    "reader",
+		Operations: []authorization.Operation{authorization.ReadProduct},
+		Scopes:     []string{"foo"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		args func(t *testing.T) []string
+		code int
+	}{
+		{"ok", func(*testing.T) []string { return []string{"-auth-dev", "list"} }, exitOK},
+		{"publication failed", func(t *testing.T) []string {
+			return []string{"-auth-dev", "-publication-webhook-url", failing(t), "publish", "42", "-channel", "web"}
+		}, exitFailure},
+		{"no command", func(*testing.T) []string { return []string{"-auth-dev"} }, exitUsage},
+		{"unknown command", func(*testing.T) []string { return []string{"-auth-dev", "frobnicate"} }, exitUsage},
+		{"unknown format", func(*testing.T) []string { return []string{"-auth-dev", "-format", "xml", "list"} }, exitUsage},
+		{"unknown flag", func(*testing.T) []string { return []string{"-auth-dev", "-frobnicate", "list"} }, exitUsage},
+		{"missing argument", func(*testing.T) []string { return []string{"-auth-dev", "get"} }, exitUsage},
+		{"invalid configuration", func(*testing.T) []string { return []string{"list"} }, exitUsage},
+		{"invalid id", func(*testing.T) []string { return []string{"-auth-dev", "create", "12345678901"} }, exitValidation},
+		{"unpublishable product", func(*testing.T) []string { return []string{"-auth-dev", "publish", "43", "-channel", "web"} }, exitValidation},
+		{"invalid token", func(*testing.T) []string { return []string{"-auth-keys", "k=secret", "-token", "garbage", "list"} }, exitForbidden},
+		{"operation not granted", func(t *testing.T) []string {
+			return []string{"-auth-keys", "k=secret", "-token", reader(t), "create", "44"}
+		}, exitForbidden},
+		{"unknown upstream product", func(*testing.T) []string { return []string{"-auth-dev", "get", "7"} }, exitNotFound},
+		{"unknown local product", func(*testing.T) []string { return []string{"-auth-dev", "history", "7"} }, exitNotFound},
+		{"upstream failure", func(t *testing.T) []string {
+			return []string{"-auth-dev", "-upstream-base-url", failing(t), "get", "42"}
+		}, exitUpstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			seed(t, dir)
+			if code, stdout, stderr := productctl(t, dir, tt.args(t)...); code != tt.code {
+				t.Fatalf("got exit code %d, want %d\nstdout: %s\nstderr: %s", code, tt.code, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	dir := t.TempDir()
+	seed(t, dir)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "EXTERNAL ID  SCOPES\n42           foo\n43           \n"},
+		{"csv", "EXTERNAL ID,SCOPES\n42,foo\n43,\n"},
+		{"json", `[
+  {
+    "Id": 0,
+    "ExternalId": "42",
+    "Scopes": [
+      "foo"
+    ]
+  },
+  {
+    "Id": 0,
+    "ExternalId": "43",
+    "Scopes": []
+  }
+]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			code, stdout, stderr := productctl(t, dir, "-auth-dev", "-format", tt.format, "list")
+			if code != exitOK {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Fatalf("got\n%q\nwant\n%q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishShowsProcess(t *testing.T) {
+	dir := t.TempDir()
+	seed(t, dir)
+	code, stdout, stderr := productctl(t, dir, "-auth-dev", "-format", "csv", "publish", "42", "-channel", "web")
+	if code != exitOK {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || lines[0] != "ID,EXTERNAL ID,CHANNEL,STATUS,STEP,IDENTIFIER,ERROR" ||
+		!strings.HasSuffix(lines[1], ",42,web,completed,complete,web-000001,") {
+		t.Fatalf("got\n%s", stdout)
+	}
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	code, stdout, _ := productctl(t, t.TempDir(), "-auth-keys", "k=very-secret", "-print-config")
+	if code != exitOK || strings.Contains(stdout, "very-secret") || !strings.Contains(stdout, `"k": "[redacted]"`) {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+}
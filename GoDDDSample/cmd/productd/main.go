@@ -0,0 +1,230 @@
+// Command productd serves products over HTTP through the application layer.
+//
+//	GET /products?text=&scope=
+//	GET /products/{id}?scope=
+//	GET /products/{id}/history
+//	GET /metrics
+//
+// Callers authenticate with a bearer token in the Authorization header. See
+// package config for configuration flags and environment variables.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"example.com/m/application"
+	"example.com/m/application/authorization"
+	"example.com/m/application/interfaces"
+	"example.com/m/application/products"
+	"example.com/m/application/publication"
+	"example.com/m/cmd/internal/bootstrap"
+	"example.com/m/config"
+	"example.com/m/infrastructure"
+	"example.com/m/metrics"
+	"example.com/m/tracing"
+)
+
+type server struct {
+	keys       infrastructure.StaticKeySet
+	policy     authorization.Policy
+	upstream   interfaces.ProductInformation
+	repository interfaces.ProductRepository
+}
+
+func main() {
+	flags := flag.NewFlagSet("productd", flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print effective configuration and exit")
+	c, err := config.Load(flags, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		c.Print(os.Stdout)
+		return
+	}
+	if bootstrap.TrustsDevKey(c) {
+		fmt.Fprintln(os.Stderr, "productd: refusing to trust the development key, configure auth.keys instead")
+		os.Exit(2)
+	}
+
+	closeTrace, err := bootstrap.Observability(c, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer closeTrace()
+
+	s := server{
+		keys:       bootstrap.KeySet(c),
+		policy:     bootstrap.Policy(c),
+		upstream:   bootstrap.ProductInformation(c),
+		repository: bootstrap.ProductRepository(c)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /products", s.authenticated(s.searchProducts))
+	mux.HandleFunc("GET /products/{id}", s.authenticated(s.getProduct))
+	mux.HandleFunc("GET /products/{id}/history", s.authenticated(s.getProductHistory))
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+
+	go sweepPublications(bootstrap.ProcessManager(c, s.repository), c.Publication.StepTimeout.Duration)
+
+	srv := &http.Server{
+		Addr:    c.Server.Addr,
+		Handler: withCorrelation(mux),
+		// Requests are GETs without a body, so they're read quickly, while a
+		// response may wait for upstream.
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      c.Upstream.Timeout.Duration + 10*time.Second,
+		IdleTimeout:       2 * time.Minute}
+	slog.Info("listening", "addr", c.Server.Addr)
+	if err := srv.ListenAndServe(); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// sweepPublications periodically fails and compensates publication processes
+// past their deadline, e.g., those left running by a crashed productctl.
+func sweepPublications(m publication.ProcessManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := tracing.WithCorrelationId(context.Background(), tracing.NewId())
+		if errs := m.CheckTimeouts(ctx); errs != nil {
+			slog.WarnContext(ctx, "unable to check publication timeouts", "errors", errs)
+		}
+	}
+}
+
+var validCorrelationId = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withCorrelation reuses the caller's correlation id, if well-formed, or
+// generates one, and echoes it in the response.
+func withCorrelation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(tracing.Header)
+		if !validCorrelationId.MatchString(id) {
+			id = tracing.NewId()
+		}
+		w.Header().Set(tracing.Header, id)
+
+		start := time.Now()
+		ctx, span := tracing.Start(tracing.WithCorrelationId(r.Context(), id), "http", r.Method+" "+r.URL.Path)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		failed := 0
+		if rec.status >= 500 {
+			failed = 1
+		}
+		span.End(failed)
+		slog.InfoContext(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000)
+	})
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, principal authorization.Principal)
+
+func (s server) authenticated(next authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.keys.Validate(r.Header.Get("Authorization"))
+		if err != nil {
+			slog.InfoContext(r.Context(), "authentication failed", "error", err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeErrors(r.Context(), w, http.StatusUnauthorized, []error{err})
+			return
+		}
+		next(w, r, principal)
+	}
+}
+
+func (s server) getProduct(w http.ResponseWriter, r *http.Request, principal authorization.Principal) {
+	p, errs := products.GetProductByIdQuery{
+		Id:                 r.PathValue("id"),
+		Scopes:             r.URL.Query()["scope"],
+		Principal:          principal,
+		Policy:             s.policy,
+		ProductInformation: s.upstream}.Run(r.Context())
+	respond(r.Context(), w, p, errs)
+}
+
+func (s server) searchProducts(w http.ResponseWriter, r *http.Request, principal authorization.Principal) {
+	ps, errs := products.SearchProductsQuery{
+		Text:              r.URL.Query().Get("text"),
+		Scope:             r.URL.Query().Get("scope"),
+		Principal:         principal,
+		Policy:            s.policy,
+		ProductRepository: s.repository}.Run(r.Context())
+	respond(r.Context(), w, ps, errs)
+}
+
+func (s server) getProductHistory(w http.ResponseWriter, r *http.Request, principal authorization.Principal) {
+	events, errs := products.GetProductHistoryQuery{
+		Id:                r.PathValue("id"),
+		Principal:         principal,
+		Policy:            s.policy,
+		ProductRepository: s.repository}.Run(r.Context())
+	respond(r.Context(), w, events, errs)
+}
+
+func respond(ctx context.Context, w http.ResponseWriter, v any, errs []error) {
+	if len(errs) > 0 {
+		writeErrors(ctx, w, statusCode(errs), errs)
+		return
+	}
+	writeJSON(ctx, w, http.StatusOK, v)
+}
+
+func statusCode(errs []error) int {
+	switch application.Classify(errs) {
+	case application.InvalidInput:
+		return http.StatusBadRequest
+	case application.Forbidden:
+		return http.StatusForbidden
+	case application.NotFound:
+		return http.StatusNotFound
+	case application.UpstreamFailure:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeErrors(ctx context.Context, w http.ResponseWriter, status int, errs []error) {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	writeJSON(ctx, w, status, struct {
+		Errors []string `json:"errors"`
+	}{messages})
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.WarnContext(ctx, "unable to write response", "error", err)
+	}
+}
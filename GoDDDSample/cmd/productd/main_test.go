@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"example.com/m/tracing"
+)
+
+func TestWithCorrelation(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	tests := []struct {
+		name   string
+		header string
+		want   string // Empty for a generated id.
+	}{
+		{"reused", "request-1", "request-1"},
+		{"longest", strings.Repeat("a", 64), strings.Repeat("a", 64)},
+		{"missing", "", ""},
+		{"malformed", "request 1\n", ""},
+		{"too long", strings.Repeat("a", 65), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := withCorrelation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = tracing.CorrelationId(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/products", nil)
+			if tt.header != "" {
+				req.Header.Set(tracing.Header, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(tracing.Header)
+			if echoed != seen {
+				t.Fatalf("echoed %q, but handler saw %q", echoed, seen)
+			}
+			if tt.want != "" && seen != tt.want || tt.want == "" && !generated.MatchString(seen) {
+				t.Fatalf("got %q, want %q", seen, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+// Package config loads settings for the commands. Values are layered with
+// later layers taking precedence: defaults, JSON file, environment variables,
+// and finally command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Upstream    UpstreamConfig    `json:"upstream"`
+	Auth        AuthConfig        `json:"auth"`
+	Store       StoreConfig       `json:"store"`
+	Query       QueryConfig       `json:"query"`
+	Server      ServerConfig      `json:"server"`
+	Log         LogConfig         `json:"log"`
+	Publication PublicationConfig `json:"publication"`
+}
+
+type UpstreamConfig struct {
+	// BaseURL of Stibo DaaS. When empty, a built-in stub is used instead.
+	BaseURL      string   `json:"baseUrl"`
+	Timeout      Duration `json:"timeout"`
+	ClientId     string   `json:"clientId"`
+	ClientSecret Secret   `json:"clientSecret"`
+	// CacheTTL of products read from upstream. Zero disables the cache.
+	CacheTTL  Duration `json:"cacheTtl"`
+	CacheSize int      `json:"cacheSize"`
+	// BreakerThreshold is the number of consecutive failures which opens the
+	// circuit breaker for BreakerCooldown. Zero disables the breaker.
+	BreakerThreshold int      `json:"breakerThreshold"`
+	BreakerCooldown  Duration `json:"breakerCooldown"`
+}
+
+type AuthConfig struct {
+	// Keys are the static keys bearer tokens are validated against, indexed
+	// by key id.
+	Keys      map[string]Secret `json:"keys"`
+	ScopeMode string            `json:"scopeMode"`
+	Token     Secret            `json:"token"`
+	// Dev trusts a publicly known development key in place of Keys, and
+	// without Token, acts as a development user. Only productctl allows it.
+	Dev bool `json:"dev"`
+}
+
+type StoreConfig struct {
+	Path string `json:"path"`
+}
+
+type ServerConfig struct {
+	Addr string `json:"addr"`
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	// TraceFile receives span timings as JSON lines. Empty disables tracing.
+	TraceFile string `json:"traceFile"`
+}
+
+type PublicationConfig struct {
+	StorePath      string `json:"storePath"`
+	IdentifierPath string `json:"identifierPath"`
+	// WebhookURL is notified of published products. When empty, notices are
+	// only logged.
+	WebhookURL  string   `json:"webhookUrl"`
+	StepTimeout Duration `json:"stepTimeout"`
+	Timeout     Duration `json:"timeout"`
+}
+
+type QueryConfig struct {
+	Id     string   `json:"id"`
+	Scopes []string `json:"scopes"`
+}
+
+func Default() Config {
+	return Config{
+		Upstream: UpstreamConfig{
+			Timeout:          Duration{10 * time.Second},
+			CacheSize:        1000,
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration{30 * time.Second}},
+		Auth: AuthConfig{
+			ScopeMode: "reject"},
+		Store:  StoreConfig{Path: "products.json"},
+		Query:  QueryConfig{Id: "123"},
+		Server: ServerConfig{Addr: "127.0.0.1:8080"},
+		Log:    LogConfig{Level: "info", Format: "text"},
+		Publication: PublicationConfig{
+			StorePath:      "publications.json",
+			IdentifierPath: "identifiers.json",
+			StepTimeout:    Duration{10 * time.Second},
+			Timeout:        Duration{time.Minute}}}
+}
+
+// Secret is a string which doesn't reveal its value when printed or
+// marshaled. Use Reveal to get at the value.
+type Secret string
+
+func (s Secret) Reveal() string { return string(s) }
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// Duration is a time.Duration written as "10s" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// setting binds a configuration value to a flag and an environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"upstream-base-url", "PRODUCT_UPSTREAM_BASE_URL", "Stibo DaaS base URL, empty for built-in stub",
+		func(c *Config, v string) error { c.Upstream.BaseURL = v; return nil }},
+	{"upstream-timeout", "PRODUCT_UPSTREAM_TIMEOUT", "timeout of upstream requests",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.Upstream.Timeout = Duration{d}
+			return err
+		}},
+	{"upstream-client-id", "PRODUCT_UPSTREAM_CLIENT_ID", "upstream client id",
+		func(c *Config, v string) error { c.Upstream.ClientId = v; return nil }},
+	{"upstream-client-secret", "PRODUCT_UPSTREAM_CLIENT_SECRET", "upstream client secret",
+		func(c *Config, v string) error { c.Upstream.ClientSecret = Secret(v); return nil }},
+	{"upstream-cache-ttl", "PRODUCT_UPSTREAM_CACHE_TTL", "how long to cache upstream products, 0 disables",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.Upstream.CacheTTL = Duration{d}
+			return err
+		}},
+	{"upstream-cache-size", "PRODUCT_UPSTREAM_CACHE_SIZE", "maximum number of cached upstream products",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			c.Upstream.CacheSize = n
+			return err
+		}},
+	{"upstream-breaker-threshold", "PRODUCT_UPSTREAM_BREAKER_THRESHOLD", "consecutive upstream failures opening the circuit breaker, 0 disables",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			c.Upstream.BreakerThreshold = n
+			return err
+		}},
+	{"upstream-breaker-cooldown", "PRODUCT_UPSTREAM_BREAKER_COOLDOWN", "how long the circuit breaker stays open",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.Upstream.BreakerCooldown = Duration{d}
+			return err
+		}},
+	{"auth-keys", "PRODUCT_AUTH_KEYS", "bearer token keys as kid=key,...",
+		func(c *Config, v string) error {
+			keys := map[string]Secret{}
+			c.Auth.Keys = keys
+			if strings.TrimSpace(v) == "" {
+				return nil
+			}
+			for _, pair := range strings.Split(v, ",") {
+				kid, key, ok := strings.Cut(pair, "=")
+				if !ok {
+					return errors.New("expected kid=key")
+				}
+				keys[strings.TrimSpace(kid)] = Secret(strings.TrimSpace(key))
+			}
+			return nil
+		}},
+	{"auth-scope-mode", "PRODUCT_AUTH_SCOPE_MODE", "reject or trim scopes the caller isn't granted",
+		func(c *Config, v string) error { c.Auth.ScopeMode = v; return nil }},
+	{"auth-dev", "PRODUCT_AUTH_DEV", "act as a development user with a development key, productctl only",
+		func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			c.Auth.Dev = b
+			return err
+		}},
+	{"token", "PRODUCT_BEARER_TOKEN", "bearer token of the caller",
+		func(c *Config, v string) error { c.Auth.Token = Secret(v); return nil }},
+	{"store", "PRODUCT_STORE_PATH", "local product store file",
+		func(c *Config, v string) error { c.Store.Path = v; return nil }},
+	{"addr", "PRODUCT_ADDR", "address the server listens on",
+		func(c *Config, v string) error { c.Server.Addr = v; return nil }},
+	{"log-level", "PRODUCT_LOG_LEVEL", "debug, info, warn or error",
+		func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"log-format", "PRODUCT_LOG_FORMAT", "text or json",
+		func(c *Config, v string) error { c.Log.Format = v; return nil }},
+	{"trace-file", "PRODUCT_TRACE_FILE", "file to append span timings to as JSON lines",
+		func(c *Config, v string) error { c.Log.TraceFile = v; return nil }},
+	{"publication-store", "PRODUCT_PUBLICATION_STORE_PATH", "publication process store file",
+		func(c *Config, v string) error { c.Publication.StorePath = v; return nil }},
+	{"publication-identifiers", "PRODUCT_PUBLICATION_IDENTIFIER_PATH", "reserved channel identifiers file",
+		func(c *Config, v string) error { c.Publication.IdentifierPath = v; return nil }},
+	{"publication-webhook-url", "PRODUCT_PUBLICATION_WEBHOOK_URL", "URL notified of published products, empty to only log",
+		func(c *Config, v string) error { c.Publication.WebhookURL = v; return nil }},
+	{"publication-step-timeout", "PRODUCT_PUBLICATION_STEP_TIMEOUT", "timeout of each publication step",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.Publication.StepTimeout = Duration{d}
+			return err
+		}},
+	{"publication-timeout", "PRODUCT_PUBLICATION_TIMEOUT", "timeout of a publication as a whole",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			c.Publication.Timeout = Duration{d}
+			return err
+		}},
+	{"query-id", "PRODUCT_QUERY_ID", "external id of product to query",
+		func(c *Config, v string) error { c.Query.Id = v; return nil }},
+	{"query-scopes", "PRODUCT_QUERY_SCOPES", "comma-separated scopes to query",
+		func(c *Config, v string) error { c.Query.Scopes = splitList(v); return nil }},
+}
+
+// boolSettings are flags which, like boolean flags, may be given without a
+// value.
+var boolSettings = map[string]bool{"auth-dev": true}
+
+type settingValue struct {
+	value   string
+	boolean bool
+}
+
+func (v *settingValue) String() string     { return v.value }
+func (v *settingValue) Set(s string) error { v.value = s; return nil }
+func (v *settingValue) IsBoolFlag() bool   { return v.boolean }
+
+// Load registers configuration flags on flags, parses args, and returns the
+// validated configuration. Callers may register flags of their own before
+// calling Load. The configuration file is given by -config or
+// $PRODUCT_CONFIG. lookupEnv is os.LookupEnv but for tests. An environment
+// variable set to the empty string takes precedence over the file too, e.g.,
+// PRODUCT_UPSTREAM_BASE_URL= selects the built-in stub.
+func Load(flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	configFile, _ := lookupEnv("PRODUCT_CONFIG")
+	file := flags.String("config", configFile, "JSON configuration file, defaults to $PRODUCT_CONFIG")
+	values := map[string]*settingValue{}
+	for _, s := range settings {
+		values[s.flag] = &settingValue{boolean: boolSettings[s.flag]}
+		flags.Var(values[s.flag], s.flag, s.usage+" ($"+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	c := Default()
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(&c, v); err != nil {
+				return Config{}, fmt.Errorf("$%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if f.Name == s.flag && err == nil {
+				if e := s.set(&c, values[s.flag].value); e != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if errs := c.Validate(); len(errs) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration: %w", validationErrors(errs))
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c Config) Validate() []error {
+	errs := []error{}
+	if c.Upstream.BaseURL != "" {
+		u, err := url.Parse(c.Upstream.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("upstream.baseUrl: %q isn't an absolute http(s) URL", c.Upstream.BaseURL))
+		}
+	}
+	if c.Upstream.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("upstream.timeout: must be positive"))
+	}
+	if (c.Upstream.ClientId == "") != (c.Upstream.ClientSecret == "") {
+		errs = append(errs, errors.New("upstream.clientId and upstream.clientSecret must be given together"))
+	}
+	if c.Upstream.CacheTTL.Duration < 0 {
+		errs = append(errs, errors.New("upstream.cacheTtl: must not be negative"))
+	}
+	if c.Upstream.CacheTTL.Duration > 0 && c.Upstream.CacheSize <= 0 {
+		errs = append(errs, errors.New("upstream.cacheSize: must be positive when caching"))
+	}
+	if c.Upstream.BreakerThreshold < 0 {
+		errs = append(errs, errors.New("upstream.breakerThreshold: must not be negative"))
+	}
+	if c.Upstream.BreakerThreshold > 0 && c.Upstream.BreakerCooldown.Duration <= 0 {
+		errs = append(errs, errors.New("upstream.breakerCooldown: must be positive when the breaker is enabled"))
+	}
+	if len(c.Auth.Keys) == 0 && !c.Auth.Dev {
+		errs = append(errs, errors.New("auth.keys: at least one key required outside dev mode"))
+	}
+	for kid, key := range c.Auth.Keys {
+		if kid == "" || key == "" {
+			errs = append(errs, errors.New("auth.keys: key ids and keys must be non-empty"))
+			break
+		}
+	}
+	if c.Auth.ScopeMode != "reject" && c.Auth.ScopeMode != "trim" {
+		errs = append(errs, fmt.Errorf("auth.scopeMode: %q isn't reject or trim", c.Auth.ScopeMode))
+	}
+	if c.Store.Path == "" {
+		errs = append(errs, errors.New("store.path: required"))
+	}
+	if c.Publication.StorePath == "" || c.Publication.IdentifierPath == "" {
+		errs = append(errs, errors.New("publication.storePath and publication.identifierPath: required"))
+	}
+	if c.Publication.WebhookURL != "" {
+		u, err := url.Parse(c.Publication.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("publication.webhookUrl: %q isn't an absolute http(s) URL", c.Publication.WebhookURL))
+		}
+	}
+	if c.Publication.StepTimeout.Duration <= 0 || c.Publication.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("publication.stepTimeout and publication.timeout: must be positive"))
+	}
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr: required"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %q isn't debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format: %q isn't text or json", c.Log.Format))
+	}
+	return errs
+}
+
+// Print writes the effective configuration with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func splitList(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+type validationErrors []error
+
+// Error sorts messages as Validate iterates over a map.
+func (v validationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, err.Error())
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, args []string, env map[string]string) (Config, error) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return Load(flags, args, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+}
+
+func TestLoadAuth(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		dev   bool
+		keys  int
+		valid bool
+	}{
+		{"defaults have no keys", nil, nil, false, 0, false},
+		{"dev flag", []string{"-auth-dev"}, nil, true, 0, true},
+		{"dev flag with value", []string{"-auth-dev=false"}, nil, false, 0, false},
+		{"dev environment variable", nil, map[string]string{"PRODUCT_AUTH_DEV": "true"}, true, 0, true},
+		{"keys", []string{"-auth-keys", "a=secret"}, nil, false, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := load(t, tt.args, tt.env)
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("got valid configuration, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Auth.Dev != tt.dev || len(c.Auth.Keys) != tt.keys {
+				t.Fatalf("got dev %v and %d keys, want %v and %d", c.Auth.Dev, len(c.Auth.Keys), tt.dev, tt.keys)
+			}
+		})
+	}
+}
+
+// writeFile writes a configuration file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, `{"upstream": {"baseUrl": "https://file.example.com", "timeout": "20s"}}`)
+	none := map[string]string{}
+
+	tests := []struct {
+		name    string
+		file    bool
+		env     map[string]string
+		args    []string
+		baseURL string
+		timeout time.Duration
+	}{
+		{"defaults", false, none, nil, "", 10 * time.Second},
+		{"file over defaults", true, none, nil, "https://file.example.com", 20 * time.Second},
+		{"environment over file", true,
+			map[string]string{"PRODUCT_UPSTREAM_BASE_URL": "https://env.example.com", "PRODUCT_UPSTREAM_TIMEOUT": "30s"}, nil,
+			"https://env.example.com", 30 * time.Second},
+		{"flags over environment", true,
+			map[string]string{"PRODUCT_UPSTREAM_BASE_URL": "https://env.example.com", "PRODUCT_UPSTREAM_TIMEOUT": "30s"},
+			[]string{"-upstream-base-url", "https://flag.example.com", "-upstream-timeout", "40s"},
+			"https://flag.example.com", 40 * time.Second},
+		{"empty environment variable over file", true,
+			map[string]string{"PRODUCT_UPSTREAM_BASE_URL": ""}, nil,
+			"", 20 * time.Second},
+		{"empty flag over environment", true,
+			map[string]string{"PRODUCT_UPSTREAM_BASE_URL": "https://env.example.com"}, []string{"-upstream-base-url="},
+			"", 20 * time.Second},
+		{"file from environment", false,
+			map[string]string{"PRODUCT_CONFIG": file}, nil,
+			"https://file.example.com", 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-auth-dev"}
+			if tt.file {
+				args = append(args, "-config", file)
+			}
+			c, err := load(t, append(args, tt.args...), tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Upstream.BaseURL != tt.baseURL || c.Upstream.Timeout.Duration != tt.timeout {
+				t.Fatalf("got base URL %q and timeout %s, want %q and %s", c.Upstream.BaseURL, c.Upstream.Timeout, tt.baseURL, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidEnvironment(t *testing.T) {
+	// Unlike strings, an empty duration isn't a value.
+	if _, err := load(t, []string{"-auth-dev"}, map[string]string{"PRODUCT_UPSTREAM_TIMEOUT": ""}); err == nil || !strings.Contains(err.Error(), "$PRODUCT_UPSTREAM_TIMEOUT") {
+		t.Fatalf("got %v, want error naming the variable", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	file := writeFile(t, `{"auth": {"keys": {"file": "file-key-value"}}}`)
+	c, err := load(t,
+		[]string{"-config", file, "-token", "flag-token-value", "-upstream-client-id", "client"},
+		map[string]string{"PRODUCT_UPSTREAM_CLIENT_SECRET": "env-client-secret-value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Auth.Keys["file"].Reveal() != "file-key-value" || c.Auth.Token.Reveal() != "flag-token-value" || c.Upstream.ClientSecret.Reveal() != "env-client-secret-value" {
+		t.Fatal("secrets not loaded")
+	}
+
+	var b strings.Builder
+	if err := c.Print(&b); err != nil {
+		t.Fatal(err)
+	}
+	for name, out := range map[string]string{"Print": b.String(), "%+v": fmt.Sprintf("%+v", c), "%v": fmt.Sprint(c)} {
+		for _, secret := range []string{"file-key-value", "flag-token-value", "env-client-secret-value"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s reveals %s:\n%s", name, secret, out)
+			}
+		}
+	}
+	if !strings.Contains(b.String(), `"clientSecret": "[redacted]"`) || !strings.Contains(b.String(), `"file": "[redacted]"`) {
+		t.Errorf("secrets not marked as redacted:\n%s", b.String())
+	}
+}
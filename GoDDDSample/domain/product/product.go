@@ -2,6 +2,7 @@ package product
 
 import (
 	"errors"
+	"time"
 
 	"example.com/m/domain"
 )
@@ -36,12 +37,58 @@ func NewScope(scope string) (Scope, error) {
 func (v Scope) Value() string           { return v.value }
 func (v Scope) Equals(other Scope) bool { return v.Value() == other.Value() }
 
+// Channel is where a product is published to, e.g., "web" or "print".
+type Channel struct {
+	value string
+}
+
+func NewChannel(channel string) (Channel, error) {
+	if channel == "" || len(channel) > 20 {
+		return Channel{}, errors.New("channel must be 1 to 20 characters")
+	}
+	for _, r := range channel {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return Channel{}, errors.New("channel must be lowercase letters, digits or dashes")
+		}
+	}
+	return Channel{value: channel}, nil
+}
+
+func (v Channel) Value() string             { return v.value }
+func (v Channel) Equals(other Channel) bool { return v.Value() == other.Value() }
+
+type EventType string
+
+const (
+	ProductCreated       EventType = "ProductCreated"
+	ScopesUpdated        EventType = "ScopesUpdated"
+	PublicationRequested EventType = "PublicationRequested"
+	Published            EventType = "Published"
+	PublicationFailed    EventType = "PublicationFailed"
+)
+
+// Event is a domain event recorded on Product as it changes. Events are
+// collected by the repository on save. Channel and Detail are only set on
+// publication events, with Detail holding the reserved identifier or the
+// reason for failure.
+type Event struct {
+	Type       EventType
+	ExternalId ExternalProductId
+	Scopes     []Scope
+	Channel    Channel
+	Detail     string
+	OccurredAt time.Time
+}
+
 type Product struct {
 	domain.AggregateRoot
 	externalId ExternalProductId
 	scopes     []Scope
+	events     []Event
 }
 
+// NewProduct reconstitutes an existing product, e.g., one read from upstream
+// or from storage, without recording events. Use CreateProduct for new ones.
 func NewProduct(externalId ExternalProductId, scopes []Scope) (Product, []error) {
 	return Product{
 		externalId: externalId,
@@ -49,6 +96,81 @@ func NewProduct(externalId ExternalProductId, scopes []Scope) (Product, []error)
 	}, nil
 }
 
+func CreateProduct(externalId ExternalProductId, scopes []Scope) (Product, []error) {
+	p, err := NewProduct(externalId, scopes)
+	if err != nil {
+		return Product{}, err
+	}
+	p.record(ProductCreated)
+	return p, nil
+}
+
+// UpdateScopes replaces the product's scopes and reports whether anything
+// changed. Only changes are recorded as events.
+func (p *Product) UpdateScopes(scopes []Scope) bool {
+	if sameScopes(p.scopes, scopes) {
+		return false
+	}
+	p.scopes = scopes
+	p.record(ScopesUpdated)
+	return true
+}
+
+// ValidateForPublication checks the product has the attributes needed to
+// publish it.
+func (p Product) ValidateForPublication() []error {
+	if len(p.scopes) == 0 {
+		return []error{errors.New("product without scopes can't be published")}
+	}
+	return nil
+}
+
+func (p *Product) RequestPublication(channel Channel) []error {
+	if err := p.ValidateForPublication(); err != nil {
+		return err
+	}
+	p.recordPublication(PublicationRequested, channel, "")
+	return nil
+}
+
+func (p *Product) MarkPublished(channel Channel, identifier string) {
+	p.recordPublication(Published, channel, identifier)
+}
+
+func (p *Product) MarkPublicationFailed(channel Channel, reason string) {
+	p.recordPublication(PublicationFailed, channel, reason)
+}
+
+func (p *Product) record(t EventType) {
+	p.recordPublication(t, Channel{}, "")
+}
+
+func (p *Product) recordPublication(t EventType, channel Channel, detail string) {
+	scopes := make([]Scope, len(p.scopes))
+	copy(scopes, p.scopes)
+	p.events = append(p.events, Event{
+		Type:       t,
+		ExternalId: p.externalId,
+		Scopes:     scopes,
+		Channel:    channel,
+		Detail:     detail,
+		OccurredAt: time.Now().UTC()})
+}
+
+func sameScopes(a, b []Scope) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (p Product) ExternalId() ExternalProductId { return p.externalId }
 func (p Product) Scopes() []Scope               { return p.scopes }
+func (p Product) Events() []Event               { return p.events }
+func (p *Product) ClearEvents()                 { p.events = nil }
 func (p Product) Equals(other Product) bool     { return p.Id() == other.Id() }
@@ -0,0 +1,93 @@
+package infrastructure
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+// CachedProductInformation keeps successful GetProductById results for a
+// while. Product ids aren't cached as they're only used when syncing.
+type CachedProductInformation struct {
+	next       interfaces.ProductInformation
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	product   product.Product
+	expiresAt time.Time
+}
+
+func NewCachedProductInformation(next interfaces.ProductInformation, ttl time.Duration, maxEntries int) *CachedProductInformation {
+	return &CachedProductInformation{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]cacheEntry{}}
+}
+
+func (c *CachedProductInformation) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	return c.next.GetProductIds(ctx)
+}
+
+func (c *CachedProductInformation) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	key := cacheKey(id, scopes)
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expiresAt) {
+		recordCacheLookup(true)
+		return e.product, nil
+	}
+	recordCacheLookup(false)
+
+	p, err := c.next.GetProductById(ctx, id, scopes)
+	if err != nil {
+		return p, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{product: p, expiresAt: now.Add(c.ttl)}
+	return p, nil
+}
+
+// evict drops expired entries, or an arbitrary entry if none have expired.
+// The caller must hold c.mu.
+func (c *CachedProductInformation) evict(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
+
+func cacheKey(id product.ExternalProductId, scopes []product.Scope) string {
+	values := make([]string, 0, len(scopes)+1)
+	values = append(values, id.Value())
+	for _, s := range scopes {
+		values = append(values, s.Value())
+	}
+	return strings.Join(values, "\x00")
+}
+
+var _ interfaces.ProductInformation = (*CachedProductInformation)(nil)
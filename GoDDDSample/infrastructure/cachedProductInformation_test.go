@@ -0,0 +1,18 @@
+package infrastructure
+
+import (
+	"testing"
+	"time"
+
+	"example.com/m/application/interfaces/interfacestest"
+)
+
+func TestCachedProductInformationContract(t *testing.T) {
+	// A single entry has the suite evict as well as hit.
+	for _, size := range []int{1, 100} {
+		pi := NewCachedProductInformation(fake(t), time.Minute, size)
+		if err := interfacestest.TestProductInformation(pi, fixture(t)); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+	}
+}
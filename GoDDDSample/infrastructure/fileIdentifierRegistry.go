@@ -0,0 +1,88 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"example.com/m/application/publication"
+	"example.com/m/domain/product"
+)
+
+// FileIdentifierRegistry hands out sequential identifiers per channel, e.g.,
+// web-000001, and keeps reservations in a JSON file, which several processes
+// may share. Released identifiers aren't reused.
+type FileIdentifierRegistry struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+type identifierRegistryFile struct {
+	Sequences    map[string]int    `json:"sequences"`
+	Reservations map[string]string `json:"reservations"`
+	// Keys maps the keys of reservations to their identifiers.
+	Keys map[string]string `json:"keys"`
+}
+
+func (r *FileIdentifierRegistry) Reserve(ctx context.Context, key string, id product.ExternalProductId, channel product.Channel) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	f, err := r.load()
+	if err != nil {
+		return "", err
+	}
+	if identifier, ok := f.Keys[key]; ok {
+		return identifier, nil
+	}
+	f.Sequences[channel.Value()]++
+	identifier := fmt.Sprintf("%s-%06d", channel.Value(), f.Sequences[channel.Value()])
+	f.Reservations[identifier] = id.Value()
+	f.Keys[key] = identifier
+	return identifier, writeJSONFile(r.Path, f)
+}
+
+func (r *FileIdentifierRegistry) Release(ctx context.Context, channel product.Channel, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := r.load()
+	if err != nil {
+		return err
+	}
+	identifier, ok := f.Keys[key]
+	if !ok {
+		return nil
+	}
+	delete(f.Reservations, identifier)
+	delete(f.Keys, key)
+	return writeJSONFile(r.Path, f)
+}
+
+func (r *FileIdentifierRegistry) load() (identifierRegistryFile, error) {
+	f := identifierRegistryFile{}
+	if err := readJSONFile(r.Path, &f); err != nil {
+		return f, err
+	}
+	if f.Sequences == nil {
+		f.Sequences = map[string]int{}
+	}
+	if f.Reservations == nil {
+		f.Reservations = map[string]string{}
+	}
+	if f.Keys == nil {
+		f.Keys = map[string]string{}
+	}
+	return f, nil
+}
+
+var _ publication.IdentifierRegistry = (*FileIdentifierRegistry)(nil)
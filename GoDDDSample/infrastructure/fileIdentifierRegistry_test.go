@@ -0,0 +1,38 @@
+package infrastructure
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"example.com/m/domain/product"
+)
+
+func TestFileIdentifierRegistryReservesPerKey(t *testing.T) {
+	ctx := context.Background()
+	r := &FileIdentifierRegistry{Path: filepath.Join(t.TempDir(), "identifiers.json")}
+	id, _ := product.NewExternalProductId("42")
+	web, _ := product.NewChannel("web")
+
+	first, err := r.Reserve(ctx, "a", id, web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := r.Reserve(ctx, "a", id, web); err != nil || again != first {
+		t.Fatalf("reserving again got %q, %v, want %q", again, err, first)
+	}
+	if other, err := r.Reserve(ctx, "b", id, web); err != nil || other == first {
+		t.Fatalf("other key got %q, %v, want an identifier other than %q", other, err, first)
+	}
+
+	if err := r.Release(ctx, web, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Release(ctx, web, "a"); err != nil {
+		t.Fatalf("releasing twice: %s", err)
+	}
+	// Released identifiers aren't reused.
+	if next, err := r.Reserve(ctx, "a", id, web); err != nil || next == first {
+		t.Fatalf("after release got %q, %v, want a new identifier", next, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package infrastructure
+
+import "os"
+
+// flock does nothing where flock(2) isn't available, leaving only the
+// in-process mutexes of the stores to guard their files.
+func flock(f *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,218 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+	"example.com/m/tracing"
+)
+
+// FileProductRepository keeps products and their history in a single JSON
+// file, which productctl and productd may share. Every operation reads or
+// rewrites the whole file under a file lock, which is fine for a catalogue of
+// modest size but doesn't scale to a large one.
+type FileProductRepository struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+type productRecord struct {
+	ExternalId string        `json:"externalId"`
+	Scopes     []string      `json:"scopes"`
+	History    []eventRecord `json:"history"`
+}
+
+type eventRecord struct {
+	Type       string    `json:"type"`
+	Scopes     []string  `json:"scopes"`
+	Channel    string    `json:"channel,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (r *FileProductRepository) GetProducts(ctx context.Context) (_ []product.Product, errs []error) {
+	_, span := tracing.Start(ctx, "infrastructure", "FileProductRepository.GetProducts")
+	defer func() { span.End(len(errs)) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, false)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer unlock()
+	records, err := r.load()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	ids := make([]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	errs = []error{}
+	ps := make([]product.Product, 0, len(ids))
+	for _, id := range ids {
+		p, err := records[id].toProduct()
+		errs = append(errs, err...)
+		ps = append(ps, p)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ps, nil
+}
+
+func (r *FileProductRepository) GetProduct(ctx context.Context, id product.ExternalProductId) (_ product.Product, errs []error) {
+	_, span := tracing.Start(ctx, "infrastructure", "FileProductRepository.GetProduct")
+	defer func() { span.End(len(errs)) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, false)
+	if err != nil {
+		return product.Product{}, []error{err}
+	}
+	defer unlock()
+	records, err := r.load()
+	if err != nil {
+		return product.Product{}, []error{err}
+	}
+	record, ok := records[id.Value()]
+	if !ok {
+		return product.Product{}, []error{fmt.Errorf("%w: %s", interfaces.ErrProductNotFound, id.Value())}
+	}
+	return record.toProduct()
+}
+
+func (r *FileProductRepository) SaveProduct(ctx context.Context, p product.Product) (errs []error) {
+	_, span := tracing.Start(ctx, "infrastructure", "FileProductRepository.SaveProduct")
+	defer func() { span.End(len(errs)) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, true)
+	if err != nil {
+		return []error{err}
+	}
+	defer unlock()
+	records, err := r.load()
+	if err != nil {
+		return []error{err}
+	}
+
+	record := records[p.ExternalId().Value()]
+	record.ExternalId = p.ExternalId().Value()
+	record.Scopes = scopeValues(p.Scopes())
+	for _, e := range p.Events() {
+		record.History = append(record.History, eventRecord{
+			Type:       string(e.Type),
+			Scopes:     scopeValues(e.Scopes),
+			Channel:    e.Channel.Value(),
+			Detail:     e.Detail,
+			OccurredAt: e.OccurredAt})
+	}
+	records[record.ExternalId] = record
+
+	if err := r.store(records); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func (r *FileProductRepository) GetProductHistory(ctx context.Context, id product.ExternalProductId) (_ []product.Event, errs []error) {
+	_, span := tracing.Start(ctx, "infrastructure", "FileProductRepository.GetProductHistory")
+	defer func() { span.End(len(errs)) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock, err := lockFile(r.Path, false)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer unlock()
+	records, err := r.load()
+	if err != nil {
+		return nil, []error{err}
+	}
+	record, ok := records[id.Value()]
+	if !ok {
+		return nil, []error{fmt.Errorf("%w: %s", interfaces.ErrProductNotFound, id.Value())}
+	}
+
+	errs = []error{}
+	events := make([]product.Event, 0, len(record.History))
+	for _, e := range record.History {
+		var channel product.Channel
+		if e.Channel != "" {
+			var err error
+			if channel, err = product.NewChannel(e.Channel); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		events = append(events, product.Event{
+			Type:       product.EventType(e.Type),
+			ExternalId: id,
+			Scopes:     toScopes(e.Scopes, &errs),
+			Channel:    channel,
+			Detail:     e.Detail,
+			OccurredAt: e.OccurredAt})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return events, nil
+}
+
+func (r *FileProductRepository) load() (map[string]productRecord, error) {
+	records := map[string]productRecord{}
+	return records, readJSONFile(r.Path, &records)
+}
+
+func (r *FileProductRepository) store(records map[string]productRecord) error {
+	return writeJSONFile(r.Path, records)
+}
+
+func (record productRecord) toProduct() (product.Product, []error) {
+	errs := []error{}
+	id, err := product.NewExternalProductId(record.ExternalId)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	scopes := toScopes(record.Scopes, &errs)
+	if len(errs) > 0 {
+		return product.Product{}, errs
+	}
+	return product.NewProduct(id, scopes)
+}
+
+func toScopes(values []string, errs *[]error) []product.Scope {
+	scopes := make([]product.Scope, 0, len(values))
+	for _, v := range values {
+		s, err := product.NewScope(v)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
+
+func scopeValues(scopes []product.Scope) []string {
+	values := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		values = append(values, s.Value())
+	}
+	return values
+}
+
+var _ interfaces.ProductRepository = (*FileProductRepository)(nil)
@@ -0,0 +1,81 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"example.com/m/application/publication"
+)
+
+// FilePublicationStore keeps publication processes in a single JSON file,
+// which several processes may share.
+type FilePublicationStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FilePublicationStore) Save(ctx context.Context, p publication.Publication) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.Path, true)
+	if err != nil {
+		return []error{err}
+	}
+	defer unlock()
+	ps := map[string]publication.Publication{}
+	if err := readJSONFile(s.Path, &ps); err != nil {
+		return []error{err}
+	}
+	if stored := ps[p.Id]; p.Version != stored.Version+1 {
+		return []error{fmt.Errorf("%w: %s is at version %d, not %d", publication.ErrConflict, p.Id, stored.Version, p.Version-1)}
+	}
+	ps[p.Id] = p
+	if err := writeJSONFile(s.Path, ps); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func (s *FilePublicationStore) Get(ctx context.Context, id string) (publication.Publication, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.Path, false)
+	if err != nil {
+		return publication.Publication{}, []error{err}
+	}
+	defer unlock()
+	ps := map[string]publication.Publication{}
+	if err := readJSONFile(s.Path, &ps); err != nil {
+		return publication.Publication{}, []error{err}
+	}
+	p, ok := ps[id]
+	if !ok {
+		return publication.Publication{}, []error{fmt.Errorf("publication %q not found", id)}
+	}
+	return p, nil
+}
+
+func (s *FilePublicationStore) List(ctx context.Context) ([]publication.Publication, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.Path, false)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer unlock()
+	ps := map[string]publication.Publication{}
+	if err := readJSONFile(s.Path, &ps); err != nil {
+		return nil, []error{err}
+	}
+	list := make([]publication.Publication, 0, len(ps))
+	for _, p := range ps {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list, nil
+}
+
+var _ publication.Store = (*FilePublicationStore)(nil)
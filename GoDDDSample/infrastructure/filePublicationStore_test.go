@@ -0,0 +1,90 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"example.com/m/application/publication"
+)
+
+func TestFilePublicationStoreSaveIsOptimistic(t *testing.T) {
+	ctx := context.Background()
+	s := &FilePublicationStore{Path: filepath.Join(t.TempDir(), "publications.json")}
+
+	tests := []struct {
+		name     string
+		version  int
+		conflict bool
+	}{
+		{"insert", 1, false},
+		{"replace", 2, false},
+		{"stale", 2, true},
+		{"skipped version", 4, true},
+		{"reinsert", 1, true},
+	}
+	for _, tt := range tests {
+		errs := s.Save(ctx, publication.Publication{Id: "p", Version: tt.version})
+		if conflict := len(errs) == 1 && errors.Is(errs[0], publication.ErrConflict); conflict != tt.conflict {
+			t.Fatalf("%s: got %v, want conflict %v", tt.name, errs, tt.conflict)
+		}
+	}
+	if p, errs := s.Get(ctx, "p"); len(errs) > 0 || p.Version != 2 {
+		t.Fatalf("got version %d and %v, want 2", p.Version, errs)
+	}
+}
+
+// TestFilePublicationStoreShared has stores of their own, as processes would,
+// share a file.
+func TestFilePublicationStoreShared(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "publications.json")
+	const stores = 20
+
+	run := func(save func(s *FilePublicationStore, i int) []error) []error {
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs []error
+		)
+		for i := 0; i < stores; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := save(&FilePublicationStore{Path: path}, i)
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err...)
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+
+	// No insert is lost.
+	if errs := run(func(s *FilePublicationStore, i int) []error {
+		return s.Save(ctx, publication.Publication{Id: strconv.Itoa(i), Version: 1})
+	}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	ps, errs := (&FilePublicationStore{Path: path}).List(ctx)
+	if len(errs) > 0 || len(ps) != stores {
+		t.Fatalf("got %d publications and %v, want %d", len(ps), errs, stores)
+	}
+
+	// Only one of the stores claims a publication.
+	errs = run(func(s *FilePublicationStore, i int) []error {
+		return s.Save(ctx, publication.Publication{Id: "0", Version: 2})
+	})
+	for _, err := range errs {
+		if !errors.Is(err, publication.ErrConflict) {
+			t.Fatal(err)
+		}
+	}
+	if len(errs) != stores-1 {
+		t.Fatalf("got %d conflicts, want %d", len(errs), stores-1)
+	}
+}
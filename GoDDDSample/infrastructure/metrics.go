@@ -0,0 +1,61 @@
+package infrastructure
+
+import (
+	"sync/atomic"
+
+	"example.com/m/metrics"
+)
+
+var (
+	upstreamRequests = metrics.Default.Counter(
+		"product_upstream_requests_total",
+		"Requests to Stibo DaaS, by HTTP status or transport_error.",
+		"endpoint", "status")
+	upstreamDuration = metrics.Default.Histogram(
+		"product_upstream_request_duration_seconds",
+		"Duration of requests to Stibo DaaS.",
+		metrics.DefaultBuckets,
+		"endpoint")
+	upstreamErrors = metrics.Default.Counter(
+		"product_upstream_errors_total",
+		"Failed requests to Stibo DaaS, by reason.",
+		"endpoint", "reason")
+
+	cacheRequests = metrics.Default.Counter(
+		"product_cache_requests_total",
+		"Product cache lookups, by hit or miss.",
+		"result")
+	cacheHits, cacheLookups atomic.Uint64
+
+	breakerState = metrics.Default.Gauge(
+		"product_circuit_breaker_state",
+		"Circuit breaker state, by breaker: 0 closed, 1 half-open, 2 open.",
+		"breaker")
+	breakerTransitions = metrics.Default.Counter(
+		"product_circuit_breaker_transitions_total",
+		"Circuit breaker state changes, by breaker and state entered.",
+		"breaker", "state")
+)
+
+func init() {
+	metrics.Default.GaugeFunc(
+		"product_cache_hit_ratio",
+		"Share of product cache lookups served from the cache since start.",
+		func() float64 {
+			lookups := cacheLookups.Load()
+			if lookups == 0 {
+				return 0
+			}
+			return float64(cacheHits.Load()) / float64(lookups)
+		})
+}
+
+func recordCacheLookup(hit bool) {
+	cacheLookups.Add(1)
+	if hit {
+		cacheHits.Add(1)
+		cacheRequests.Inc("hit")
+	} else {
+		cacheRequests.Inc("miss")
+	}
+}
@@ -0,0 +1,35 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"example.com/m/application/interfaces/interfacestest"
+	"example.com/m/domain/product"
+)
+
+// fixture describes product 42 with scope foo, which the stub, the fake and
+// the fake upstream of StiboDaaSClient all serve, while 7 doesn't exist.
+func fixture(t *testing.T) interfacestest.Fixture {
+	t.Helper()
+	known, err := product.NewExternalProductId("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := product.NewExternalProductId("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, err := product.NewScope("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return interfacestest.Fixture{
+		Known:   []product.ExternalProductId{known},
+		Unknown: unknown,
+		Scopes:  []product.Scope{foo}}
+}
+
+func fake(t *testing.T) *interfacestest.Fake {
+	t.Helper()
+	return interfacestest.NewFake(interfacestest.NewProduct(t, "42", "foo"))
+}
@@ -0,0 +1,139 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+var circuitStateNames = map[circuitState]string{circuitClosed: "closed", circuitHalfOpen: "half_open", circuitOpen: "open"}
+
+// ResilientProductInformation puts a circuit breaker in front of upstream.
+// After threshold consecutive failures, calls fail fast for cooldown. Then a
+// single trial call decides whether to close the circuit again. Not found
+// isn't a failure of upstream. Its state is reported under name, so each
+// upstream needs a breaker of its own name.
+type ResilientProductInformation struct {
+	name      string
+	next      interfaces.ProductInformation
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	// trial is the generation of the call let through while half-open, or
+	// zero if none is in flight. Every call gets the next generation, so a
+	// call started before the circuit opened can't pass for the trial.
+	trial      uint64
+	generation uint64
+}
+
+func NewResilientProductInformation(name string, next interfaces.ProductInformation, threshold int, cooldown time.Duration) *ResilientProductInformation {
+	breakerState.Set(float64(circuitClosed), name)
+	return &ResilientProductInformation{name: name, next: next, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (r *ResilientProductInformation) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	call, err := r.allow(ctx)
+	if err != nil {
+		return nil, []error{err}
+	}
+	ids, errs := r.next.GetProductIds(ctx)
+	r.done(ctx, call, errs)
+	return ids, errs
+}
+
+func (r *ResilientProductInformation) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	call, err := r.allow(ctx)
+	if err != nil {
+		return product.Product{}, []error{err}
+	}
+	p, errs := r.next.GetProductById(ctx, id, scopes)
+	r.done(ctx, call, errs)
+	return p, errs
+}
+
+// allow returns the generation of the call, which is passed on to done.
+func (r *ResilientProductInformation) allow(ctx context.Context) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.state {
+	case circuitOpen:
+		if r.now().Sub(r.openedAt) < r.cooldown {
+			return 0, ErrCircuitOpen
+		}
+		r.transition(ctx, circuitHalfOpen)
+	case circuitHalfOpen:
+		if r.trial != 0 {
+			return 0, ErrCircuitOpen
+		}
+	}
+	r.generation++
+	if r.state == circuitHalfOpen {
+		r.trial = r.generation
+	}
+	return r.generation, nil
+}
+
+func (r *ResilientProductInformation) done(ctx context.Context, call uint64, errs []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != circuitClosed && call != r.trial {
+		// Started before the circuit opened, so it says nothing about
+		// whether upstream has recovered.
+		return
+	}
+	r.trial = 0
+	if !failed(errs) {
+		r.failures = 0
+		if r.state != circuitClosed {
+			r.transition(ctx, circuitClosed)
+		}
+		return
+	}
+
+	r.failures++
+	if r.state == circuitHalfOpen || r.failures >= r.threshold {
+		r.openedAt = r.now()
+		if r.state != circuitOpen {
+			r.transition(ctx, circuitOpen)
+		}
+	}
+}
+
+// transition must be called with r.mu held.
+func (r *ResilientProductInformation) transition(ctx context.Context, to circuitState) {
+	slog.WarnContext(ctx, "circuit breaker", "breaker", r.name, "from", circuitStateNames[r.state], "to", circuitStateNames[to])
+	r.state = to
+	breakerState.Set(float64(to), r.name)
+	breakerTransitions.Inc(r.name, circuitStateNames[to])
+}
+
+func failed(errs []error) bool {
+	for _, err := range errs {
+		if !errors.Is(err, interfaces.ErrProductNotFound) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ interfaces.ProductInformation = (*ResilientProductInformation)(nil)
@@ -0,0 +1,136 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/m/application/interfaces/interfacestest"
+	"example.com/m/domain/product"
+	"example.com/m/metrics"
+)
+
+func TestResilientProductInformationContract(t *testing.T) {
+	pi := NewResilientProductInformation("contract", fake(t), 1, time.Minute)
+	if err := interfacestest.TestProductInformation(pi, fixture(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gated blocks GetProductById for an id until its result is released.
+type gated struct {
+	mu      sync.Mutex
+	gates   map[string]chan []error
+	entered chan string
+}
+
+func newGated() *gated {
+	return &gated{gates: map[string]chan []error{}, entered: make(chan string, 10)}
+}
+
+func (g *gated) gate(id string) chan []error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.gates[id]; !ok {
+		g.gates[id] = make(chan []error, 1)
+	}
+	return g.gates[id]
+}
+
+func (g *gated) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	return nil, nil
+}
+
+func (g *gated) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	g.entered <- id.Value()
+	return product.Product{}, <-g.gate(id.Value())
+}
+
+// clock is advanced by hand.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestResilientProductInformationStragglerIsNoTrial(t *testing.T) {
+	upstream := newGated()
+	c := &clock{t: time.Now()}
+	pi := NewResilientProductInformation("straggler", upstream, 1, time.Minute)
+	pi.now = c.now
+	get := func(id string) chan []error {
+		externalId, _ := product.NewExternalProductId(id)
+		result := make(chan []error, 1)
+		go func() {
+			_, errs := pi.GetProductById(context.Background(), externalId, nil)
+			result <- errs
+		}()
+		return result
+	}
+
+	straggler := get("slow")
+	<-upstream.entered
+
+	// A failure opens the circuit while the straggler is still in flight.
+	upstream.gate("failing") <- []error{errors.New("unavailable")}
+	<-get("failing")
+	<-upstream.entered
+	c.advance(time.Minute)
+
+	trial := get("trial")
+	<-upstream.entered
+	upstream.gate("slow") <- nil
+	if errs := <-straggler; len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// The straggler finishing mustn't let another call through.
+	upstream.gate("other") <- nil
+	if errs := <-get("other"); len(errs) != 1 || !errors.Is(errs[0], ErrCircuitOpen) {
+		t.Fatalf("got %v during trial, want %v", errs, ErrCircuitOpen)
+	}
+
+	upstream.gate("trial") <- nil
+	if errs := <-trial; len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	upstream.gate("after") <- nil
+	if errs := <-get("after"); len(errs) > 0 {
+		t.Fatalf("got %v after successful trial, want closed circuit", errs)
+	}
+}
+
+func TestResilientProductInformationStateByBreaker(t *testing.T) {
+	fail := interfacestest.NewFake()
+	fail.Inject(interfacestest.Fault{Err: errors.New("unavailable")})
+	open := NewResilientProductInformation("failing_upstream", fail, 1, time.Minute)
+	NewResilientProductInformation("healthy_upstream", fake(t), 1, time.Minute)
+	open.GetProductIds(context.Background())
+
+	var b strings.Builder
+	if err := metrics.Default.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`product_circuit_breaker_state{breaker="failing_upstream"} 2`,
+		`product_circuit_breaker_state{breaker="healthy_upstream"} 0`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("no %s in\n%s", want, b.String())
+		}
+	}
+}
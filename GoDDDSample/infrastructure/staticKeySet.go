@@ -0,0 +1,145 @@
+package infrastructure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/m/application/authorization"
+)
+
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// StaticKeySet validates HS256 signed JWT bearer tokens locally against a
+// fixed set of shared keys indexed by key id. No round trip to an identity
+// provider is made.
+type StaticKeySet map[string][]byte
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type tokenClaims struct {
+	Subject    string   `json:"sub"`
+	Scope      string   `json:"scope"`
+	Operations []string `json:"ops"`
+	ExpiresAt  int64    `json:"exp"`
+	NotBefore  int64    `json:"nbf,omitempty"`
+	IssuedAt   int64    `json:"iat,omitempty"`
+}
+
+// Tolerated difference between our clock and the issuer's when checking nbf
+// and iat.
+const clockSkew = time.Minute
+
+var encoding = base64.RawURLEncoding
+
+// Validate accepts either a raw token or an Authorization header value with
+// a Bearer prefix. Tokens must expire, so exp is required, while nbf and iat
+// are checked when present.
+func (ks StaticKeySet) Validate(token string) (authorization.Principal, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "Bearer "))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return authorization.Principal{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return authorization.Principal{}, err
+	}
+	if header.Alg != "HS256" {
+		return authorization.Principal{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	key, ok := ks[header.Kid]
+	if !ok {
+		return authorization.Principal{}, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, header.Kid)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
+		return authorization.Principal{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return authorization.Principal{}, err
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 {
+		return authorization.Principal{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return authorization.Principal{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return authorization.Principal{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if claims.IssuedAt != 0 && now.Add(clockSkew).Unix() < claims.IssuedAt {
+		return authorization.Principal{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	operations := make([]authorization.Operation, 0, len(claims.Operations))
+	for _, op := range claims.Operations {
+		operations = append(operations, authorization.Operation(op))
+	}
+	return authorization.Principal{
+		Subject:    claims.Subject,
+		Operations: operations,
+		Scopes:     strings.Fields(claims.Scope)}, nil
+}
+
+// Issue mints a token for principal signed with the key identified by kid.
+// It's meant for development and tests, as production tokens are issued by
+// the identity provider.
+func (ks StaticKeySet) Issue(kid string, principal authorization.Principal, expiresAt time.Time) (string, error) {
+	key, ok := ks[kid]
+	if !ok {
+		return "", fmt.Errorf("unknown key id %q", kid)
+	}
+
+	operations := make([]string, 0, len(principal.Operations))
+	for _, op := range principal.Operations {
+		operations = append(operations, string(op))
+	}
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(tokenClaims{
+		Subject:    principal.Subject,
+		Scope:      strings.Join(principal.Scopes, " "),
+		Operations: operations,
+		ExpiresAt:  expiresAt.Unix(),
+		IssuedAt:   time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	return unsigned + "." + encoding.EncodeToString(sign(key, unsigned)), nil
+}
+
+func sign(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := encoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/m/application/authorization"
+)
+
+func TestStaticKeySetValidate(t *testing.T) {
+	keys := StaticKeySet{"test": []byte("test-key")}
+	now := time.Now().Unix()
+	hour := int64(time.Hour / time.Second)
+
+	tests := []struct {
+		name   string
+		claims tokenClaims
+		valid  bool
+	}{
+		{"valid", tokenClaims{Subject: "s", ExpiresAt: now + hour}, true},
+		{"valid with nbf and iat", tokenClaims{Subject: "s", ExpiresAt: now + hour, NotBefore: now, IssuedAt: now}, true},
+		{"no exp", tokenClaims{Subject: "s"}, false},
+		{"expired", tokenClaims{Subject: "s", ExpiresAt: now - 1}, false},
+		{"nbf in the future", tokenClaims{Subject: "s", ExpiresAt: now + 2*hour, NotBefore: now + hour}, false},
+		{"iat in the future", tokenClaims{Subject: "s", ExpiresAt: now + 2*hour, IssuedAt: now + hour}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keys.Validate(token(t, keys, "test", tt.claims))
+			if tt.valid && err != nil {
+				t.Fatalf("got %v, want valid", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestStaticKeySetIssue(t *testing.T) {
+	keys := StaticKeySet{"test": []byte("test-key")}
+	want := authorization.Principal{
+		Subject:    "s",
+		Operations: []authorization.Operation{authorization.ReadProduct},
+		Scopes:     []string{"foo", "bar"}}
+
+	token, err := keys.Issue("test", want, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := keys.Validate("Bearer " + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != want.Subject || !got.Can(authorization.ReadProduct) || !got.HasScope("foo") || !got.HasScope("bar") {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := (StaticKeySet{"test": []byte("other-key")}).Validate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token signed with another key: got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// token signs claims as is, unlike Issue which always sets exp and iat.
+func token(t *testing.T, keys StaticKeySet, kid string, claims tokenClaims) string {
+	t.Helper()
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Kid: kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	return unsigned + "." + encoding.EncodeToString(sign(keys[kid], unsigned))
+}
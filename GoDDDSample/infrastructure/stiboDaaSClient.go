@@ -1,24 +1,145 @@
 package infrastructure
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/m/application/interfaces"
 	"example.com/m/domain/product"
+	"example.com/m/tracing"
 )
 
+// StiboDaaSClient reads products from Stibo DaaS over HTTP. Credentials are
+// sent using basic authentication when ClientId is set.
 type StiboDaaSClient struct {
+	BaseURL      string
+	ClientId     string
+	ClientSecret string
+	HTTPClient   *http.Client
+}
+
+func NewStiboDaaSClient(baseURL, clientId, clientSecret string, timeout time.Duration) StiboDaaSClient {
+	return StiboDaaSClient{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		HTTPClient:   &http.Client{Timeout: timeout}}
 }
 
-func (StiboDaaSClient) GetProductIds() ([]product.ExternalProductId, []error) {
-	return nil, nil
+type productResponse struct {
+	Id     string   `json:"id"`
+	Scopes []string `json:"scopes"`
+}
+
+func (c StiboDaaSClient) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	var values []string
+	if err := c.get(ctx, "GetProductIds", "/products", nil, nil, &values); err != nil {
+		return nil, []error{err}
+	}
+
+	errs := []error{}
+	ids := make([]product.ExternalProductId, 0, len(values))
+	for _, v := range values {
+		if id, err := product.NewExternalProductId(v); err != nil {
+			errs = append(errs, fmt.Errorf("product id %q: %w", v, err))
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ids, nil
 }
 
-func (StiboDaaSClient) GetProductById(id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
-	idX, err := product.NewExternalProductId("42")
+func (c StiboDaaSClient) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	query := url.Values{}
+	for _, s := range scopes {
+		query.Add("scope", s.Value())
+	}
+	var response productResponse
+	if err := c.get(ctx, "GetProductById", "/products/"+url.PathEscape(id.Value()), query, interfaces.ErrProductNotFound, &response); err != nil {
+		return product.Product{}, []error{err}
+	}
+
+	errs := []error{}
+	externalId, err := product.NewExternalProductId(response.Id)
 	if err != nil {
-		panic("")
+		errs = append(errs, err)
 	}
-	if p, err := product.NewProduct(idX, scopes); err != nil {
-		return product.Product{}, err
-	} else {
-		return p, nil
+	productScopes := toScopes(response.Scopes, &errs)
+	if len(errs) > 0 {
+		return product.Product{}, errs
 	}
+	return product.NewProduct(externalId, productScopes)
 }
+
+// get requests path and decodes the JSON response into v. The endpoint names
+// the operation in metrics, as path holds ids. A 404 is reported as notFound,
+// if set, and otherwise as any other failing status: the collection missing
+// means the service is misconfigured, not that there are no products.
+func (c StiboDaaSClient) get(ctx context.Context, endpoint, path string, query url.Values, notFound error, v any) (err error) {
+	ctx, span := tracing.Start(ctx, "infrastructure", "GET "+path)
+	start := time.Now()
+	defer func() {
+		upstreamDuration.Observe(time.Since(start).Seconds(), endpoint)
+		if err != nil {
+			slog.WarnContext(ctx, "upstream request failed", "path", path, "error", err)
+			span.End(1)
+		} else {
+			span.End(0)
+		}
+	}()
+
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(tracing.Header, tracing.CorrelationId(ctx))
+	if c.ClientId != "" {
+		req.SetBasicAuth(c.ClientId, c.ClientSecret)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		upstreamRequests.Inc(endpoint, "transport_error")
+		upstreamErrors.Inc(endpoint, "transport")
+		return err
+	}
+	defer res.Body.Close()
+	upstreamRequests.Inc(endpoint, strconv.Itoa(res.StatusCode))
+	slog.DebugContext(ctx, "upstream request", "path", path, "status", res.StatusCode)
+
+	switch {
+	case res.StatusCode == http.StatusNotFound && notFound != nil:
+		return fmt.Errorf("%w: GET %s", notFound, path)
+	case res.StatusCode != http.StatusOK:
+		upstreamErrors.Inc(endpoint, "status")
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", path, res.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		upstreamErrors.Inc(endpoint, "decode")
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	return nil
+}
+
+var _ interfaces.ProductInformation = StiboDaaSClient{}
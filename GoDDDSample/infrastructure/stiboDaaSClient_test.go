@@ -0,0 +1,69 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/m/application"
+	"example.com/m/application/interfaces"
+	"example.com/m/application/interfaces/interfacestest"
+	"example.com/m/domain/product"
+)
+
+// daas serves product 42 with scope foo the way Stibo DaaS does, handing out
+// only the scopes asked for.
+func daas(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /products", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"42"})
+	})
+	mux.HandleFunc("GET /products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "42" {
+			http.NotFound(w, r)
+			return
+		}
+		scopes := []string{}
+		for _, s := range r.URL.Query()["scope"] {
+			if s == "foo" {
+				scopes = append(scopes, s)
+			}
+		}
+		json.NewEncoder(w).Encode(productResponse{Id: "42", Scopes: scopes})
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestStiboDaaSClientContract(t *testing.T) {
+	client := NewStiboDaaSClient(daas(t).URL, "", "", 5*time.Second)
+	if err := interfacestest.TestProductInformation(client, fixture(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStiboDaaSClientNotFound(t *testing.T) {
+	// Nothing is served, so every path is a 404.
+	s := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(s.Close)
+	client := NewStiboDaaSClient(s.URL, "", "", 5*time.Second)
+	id, _ := product.NewExternalProductId("42")
+
+	_, errs := client.GetProductById(context.Background(), id, nil)
+	if len(errs) != 1 || !errors.Is(errs[0], interfaces.ErrProductNotFound) {
+		t.Fatalf("GetProductById: got %v, want ErrProductNotFound", errs)
+	}
+
+	_, errs = client.GetProductIds(context.Background())
+	if len(errs) != 1 || errors.Is(errs[0], interfaces.ErrProductNotFound) {
+		t.Fatalf("GetProductIds: got %v, want upstream failure", errs)
+	}
+	if kind := application.Classify(application.Upstream(errs)); kind != application.UpstreamFailure {
+		t.Fatalf("GetProductIds: got kind %d, want UpstreamFailure", kind)
+	}
+}
@@ -0,0 +1,37 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/m/application/interfaces"
+	"example.com/m/domain/product"
+)
+
+// StubProductInformation stands in for Stibo DaaS when no base URL is
+// configured. It knows a single product, 42, which has the scopes asked for.
+type StubProductInformation struct {
+}
+
+const stubProductId = "42"
+
+func (StubProductInformation) GetProductIds(ctx context.Context) ([]product.ExternalProductId, []error) {
+	id, err := product.NewExternalProductId(stubProductId)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return []product.ExternalProductId{id}, nil
+}
+
+func (StubProductInformation) GetProductById(ctx context.Context, id product.ExternalProductId, scopes []product.Scope) (product.Product, []error) {
+	if id.Value() != stubProductId {
+		return product.Product{}, []error{fmt.Errorf("%w: %s", interfaces.ErrProductNotFound, id.Value())}
+	}
+	if p, err := product.NewProduct(id, scopes); err != nil {
+		return product.Product{}, err
+	} else {
+		return p, nil
+	}
+}
+
+var _ interfaces.ProductInformation = StubProductInformation{}
@@ -0,0 +1,13 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"example.com/m/application/interfaces/interfacestest"
+)
+
+func TestStubProductInformationContract(t *testing.T) {
+	if err := interfacestest.TestProductInformation(StubProductInformation{}, fixture(t)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"example.com/m/application/publication"
+	"example.com/m/tracing"
+)
+
+// WebhookNotifier posts publication notices as JSON to URL, and retracts
+// them by sending the same notice with DELETE.
+type WebhookNotifier struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, notice publication.Notice) error {
+	return n.send(ctx, http.MethodPost, notice)
+}
+
+func (n WebhookNotifier) Retract(ctx context.Context, notice publication.Notice) error {
+	return n.send(ctx, http.MethodDelete, notice)
+}
+
+func (n WebhookNotifier) send(ctx context.Context, method string, notice publication.Notice) error {
+	b, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, n.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tracing.Header, tracing.CorrelationId(ctx))
+
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", method, n.URL, res.Status)
+	}
+	return nil
+}
+
+// LogNotifier stands in for downstream systems when no webhook is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, notice publication.Notice) error {
+	slog.InfoContext(ctx, "publication notice",
+		"external_id", notice.ExternalId,
+		"channel", notice.Channel,
+		"identifier", notice.Identifier)
+	return nil
+}
+
+func (LogNotifier) Retract(ctx context.Context, notice publication.Notice) error {
+	slog.InfoContext(ctx, "publication notice retracted",
+		"external_id", notice.ExternalId,
+		"channel", notice.Channel,
+		"identifier", notice.Identifier)
+	return nil
+}
+
+var (
+	_ publication.Notifier = WebhookNotifier{}
+	_ publication.Notifier = LogNotifier{}
+)
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests, by path and status.", "path", "status")
+	g := r.Gauge("temperature", "Temperature\nin \\degrees.")
+	h := r.Histogram("duration_seconds", "Durations.", []float64{0.1, 1}, "op")
+	r.GaugeFunc("ratio", "Computed on write.", func() float64 { return 0.25 })
+	r.Histogram("idle_seconds", "Never observed.", []float64{1})
+
+	c.Inc("/b", "500")
+	c.Add(2, "/a\"\\\n", "200")
+	c.Inc("/b", "500")
+	g.Set(-1.5)
+	for _, v := range []float64{0.0625, 0.5, 2} {
+		h.Observe(v, "get")
+	}
+	h.Observe(math.Inf(1), "put")
+
+	want := `# HELP requests_total Requests, by path and status.
+# TYPE requests_total counter
+requests_total{path="/a\"\\\n",status="200"} 2
+requests_total{path="/b",status="500"} 2
+# HELP temperature Temperature\nin \\degrees.
+# TYPE temperature gauge
+temperature -1.5
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{op="get",le="0.1"} 1
+duration_seconds_bucket{op="get",le="1"} 2
+duration_seconds_bucket{op="get",le="+Inf"} 3
+duration_seconds_sum{op="get"} 2.5625
+duration_seconds_count{op="get"} 3
+duration_seconds_bucket{op="put",le="0.1"} 0
+duration_seconds_bucket{op="put",le="1"} 0
+duration_seconds_bucket{op="put",le="+Inf"} 1
+duration_seconds_sum{op="put"} +Inf
+duration_seconds_count{op="put"} 1
+# HELP ratio Computed on write.
+# TYPE ratio gauge
+ratio 0.25
+# HELP idle_seconds Never observed.
+# TYPE idle_seconds histogram
+`
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("events_total", "Events.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got, want := rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got content type %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "# HELP events_total Events.\n# TYPE events_total counter\nevents_total 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) {
+			r.Counter("x", "")
+			r.Gauge("x", "")
+		}},
+		{"missing label value", func(r *Registry) { r.Counter("x", "", "a").Inc() }},
+		{"extra label value", func(r *Registry) { r.Gauge("x", "").Set(1, "a") }},
+		{"decreasing counter", func(r *Registry) { r.Counter("x", "").Add(-1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("didn't panic")
+				}
+			}()
+			tt.run(NewRegistry())
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Package tracing carries a correlation id through a request and records
+// span-style timings of each layer the request passes through.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Header carries the correlation id on incoming and outgoing HTTP requests.
+const Header = "X-Correlation-Id"
+
+type contextKey int
+
+const (
+	correlationIdKey contextKey = iota
+	spanIdKey
+)
+
+func NewId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func WithCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIdKey, id)
+}
+
+func CorrelationId(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIdKey).(string)
+	return id
+}
+
+type Record struct {
+	CorrelationId string    `json:"correlationId"`
+	SpanId        string    `json:"spanId"`
+	ParentId      string    `json:"parentId,omitempty"`
+	Layer         string    `json:"layer"`
+	Name          string    `json:"name"`
+	Start         time.Time `json:"start"`
+	DurationMs    float64   `json:"durationMs"`
+	Errors        int       `json:"errors"`
+}
+
+type Exporter interface {
+	Export(r Record)
+}
+
+type discard struct{}
+
+func (discard) Export(Record) {}
+
+type exporterHolder struct{ Exporter }
+
+var exporter atomic.Value
+
+func init() { exporter.Store(exporterHolder{discard{}}) }
+
+// SetExporter sets where finished spans go. Spans are discarded by default.
+func SetExporter(e Exporter) { exporter.Store(exporterHolder{e}) }
+
+type Span struct {
+	ctx    context.Context
+	record Record
+}
+
+// Start begins a span as a child of any span in ctx. A correlation id is
+// generated if ctx doesn't have one, so spans always belong to a request.
+func Start(ctx context.Context, layer, name string) (context.Context, *Span) {
+	if CorrelationId(ctx) == "" {
+		ctx = WithCorrelationId(ctx, NewId())
+	}
+	parentId, _ := ctx.Value(spanIdKey).(string)
+	s := &Span{record: Record{
+		CorrelationId: CorrelationId(ctx),
+		SpanId:        NewId(),
+		ParentId:      parentId,
+		Layer:         layer,
+		Name:          name,
+		Start:         time.Now().UTC()}}
+	s.ctx = context.WithValue(ctx, spanIdKey, s.record.SpanId)
+	return s.ctx, s
+}
+
+// End finishes the span, noting how many errors the operation reported.
+func (s *Span) End(errors int) {
+	s.record.DurationMs = float64(time.Since(s.record.Start).Microseconds()) / 1000
+	s.record.Errors = errors
+	exporter.Load().(exporterHolder).Export(s.record)
+	slog.DebugContext(s.ctx, "span",
+		"layer", s.record.Layer,
+		"name", s.record.Name,
+		"duration_ms", s.record.DurationMs,
+		"errors", errors)
+}
+
+// JSONLinesExporter writes one JSON object per span and line.
+type JSONLinesExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	return &JSONLinesExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *JSONLinesExporter) Export(r Record) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(r); err != nil {
+		slog.Warn("unable to export span", "error", err)
+	}
+}
+
+// Handler adds the correlation id of the context to every log record, so
+// callers only have to use the slog context functions.
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) Handler { return Handler{h} }
+
+func (h Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := CorrelationId(ctx); id != "" {
+		r.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h Handler) WithGroup(name string) slog.Handler {
+	return Handler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder keeps exported spans.
+type recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (r *recorder) Export(record Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+func record(t *testing.T) *recorder {
+	t.Helper()
+	r := &recorder{}
+	SetExporter(r)
+	t.Cleanup(func() { SetExporter(discard{}) })
+	return r
+}
+
+func TestStartPropagatesSpans(t *testing.T) {
+	r := record(t)
+
+	ctx := WithCorrelationId(context.Background(), "request-1")
+	ctx, root := Start(ctx, "http", "GET /products")
+	childCtx, child := Start(ctx, "application", "Query")
+	_, grandchild := Start(childCtx, "infrastructure", "Repository")
+	_, sibling := Start(ctx, "application", "Other")
+	grandchild.End(0)
+	child.End(1)
+	sibling.End(0)
+	root.End(0)
+
+	if len(r.records) != 4 {
+		t.Fatalf("got %d spans, want 4", len(r.records))
+	}
+	byName := map[string]Record{}
+	for _, rec := range r.records {
+		if rec.CorrelationId != "request-1" {
+			t.Errorf("%s: got correlation id %q, want request-1", rec.Name, rec.CorrelationId)
+		}
+		byName[rec.Name] = rec
+	}
+	parents := map[string]string{
+		"GET /products": "",
+		"Query":         byName["GET /products"].SpanId,
+		"Repository":    byName["Query"].SpanId,
+		"Other":         byName["GET /products"].SpanId,
+	}
+	for name, parent := range parents {
+		if got := byName[name].ParentId; got != parent {
+			t.Errorf("%s: got parent %q, want %q", name, got, parent)
+		}
+	}
+	if byName["Query"].Errors != 1 || byName["Query"].Layer != "application" {
+		t.Errorf("got %+v", byName["Query"])
+	}
+}
+
+func TestStartGeneratesCorrelationId(t *testing.T) {
+	r := record(t)
+
+	ctx, span := Start(context.Background(), "cli", "productctl get")
+	span.End(0)
+	id := CorrelationId(ctx)
+	if len(id) != 16 || r.records[0].CorrelationId != id {
+		t.Fatalf("got correlation id %q and span %+v", id, r.records[0])
+	}
+	if other, _ := Start(context.Background(), "cli", "productctl get"); CorrelationId(other) == id {
+		t.Fatal("correlation ids aren't unique")
+	}
+}
+
+func TestCorrelationIdMissing(t *testing.T) {
+	if id := CorrelationId(context.Background()); id != "" {
+		t.Fatalf("got %q, want none", id)
+	}
+}
+
+func TestJSONLinesExporter(t *testing.T) {
+	var b bytes.Buffer
+	e := NewJSONLinesExporter(&b)
+	e.Export(Record{CorrelationId: "c", SpanId: "s", Layer: "http", Name: "first"})
+	e.Export(Record{CorrelationId: "c", SpanId: "t", ParentId: "s", Layer: "application", Name: "second", Errors: 2})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), b.String())
+	}
+	var second Record
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.ParentId != "s" || second.Errors != 2 || strings.Contains(lines[0], "parentId") {
+		t.Fatalf("got %s", b.String())
+	}
+}
+
+func TestHandlerAddsCorrelationId(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&b, nil))).With("service", "test").WithGroup("g")
+
+	logger.InfoContext(WithCorrelationId(context.Background(), "request-1"), "with")
+	logger.InfoContext(context.Background(), "without")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "correlation_id=request-1") || strings.Contains(lines[1], "correlation_id") {
+		t.Fatalf("got\n%s", b.String())
+	}
+}